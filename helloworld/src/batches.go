@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+
+	auth "helloworld/db"
+	"helloworld/extract"
+	"helloworld/kubeapi"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxUploadMemory = 32 << 20
+	maxBatchFiles   = 1000
+)
+
+// archiveLimits bound what a single uploaded archive may expand to.
+var archiveLimits = extract.Limits{
+	MaxEntries:    maxBatchFiles,
+	MaxEntrySize:  100 << 20,
+	MaxTotalSize:  4 << 30,
+	MaxZipRatio:   100,
+	AllowedSuffix: []string{".jpg", ".jpeg", ".png", ".bmp", ".webp", ".tif", ".tiff"},
+}
+
+var terminalJobStates = []string{
+	string(kubeapi.JobSucceeded),
+	string(kubeapi.JobFailed),
+	string(kubeapi.JobTimedOut),
+	string(kubeapi.JobCancelled),
+}
+
+type batchResponse struct {
+	Batch    *auth.Batch         `json:"batch"`
+	Progress *auth.BatchProgress `json:"progress"`
+	Summary  []auth.ClassSummary `json:"summary"`
+	Jobs     []*auth.Job         `json:"jobs,omitempty"`
+}
+
+// isBatchUpload reports whether the upload has to be grouped into a batch.
+func isBatchUpload(headers []*multipart.FileHeader) bool {
+	return len(headers) > 1 || (len(headers) == 1 && extract.IsArchive(headers[0].Filename))
+}
+
+// processBatch stores every uploaded file and every image of the uploaded
+// archives as part of one new batch and starts their detection jobs with the
+// model and settings of opts. The upload is checked as a whole first, so a
+// rejected archive leaves no batch behind.
+func (a *App) processBatch(ctx context.Context, user *auth.User, opts uploadOptions, headers []*multipart.FileHeader) (*auth.Batch, []*auth.Job, error) {
+	if err := validateBatch(opts, headers); err != nil {
+		return nil, nil, err
+	}
+
+	batch := &auth.Batch{ID: uuid.NewString(), Owner: user.Username}
+	if err := auth.CreateBatch(batch); err != nil {
+		return nil, nil, fmt.Errorf("recording batch: %w", err)
+	}
+	opts.BatchID = batch.ID
+
+	var jobs []*auth.Job
+	add := func(filename, contentType string, r io.Reader) error {
+		if len(jobs) >= maxBatchFiles {
+			return extract.ErrTooManyEntries
+		}
+		job, err := a.processUpload(ctx, user, opts, filename, contentType, r)
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, job)
+		return nil
+	}
+
+	for _, header := range headers {
+		file, err := header.Open()
+		if err != nil {
+			return batch, jobs, err
+		}
+		if extract.IsArchive(header.Filename) {
+			err = extract.Walk(header.Filename, file, header.Size, archiveLimits, func(e extract.Entry) error {
+				return add(path.Base(e.Name), mime.TypeByExtension(path.Ext(e.Name)), e.Body)
+			})
+		} else {
+			err = add(header.Filename, header.Header.Get("Content-Type"), file)
+		}
+		file.Close()
+		if err != nil {
+			return batch, jobs, fmt.Errorf("%s: %w", header.Filename, err)
+		}
+	}
+	return batch, jobs, nil
+}
+
+// validateBatch reads every uploaded file and archive entry without storing
+// them, and returns the error processBatch would fail with for a file the
+// client sent.
+func validateBatch(opts uploadOptions, headers []*multipart.FileHeader) error {
+	files := 0
+	check := func(filename string) error {
+		files++
+		if files > maxBatchFiles {
+			return extract.ErrTooManyEntries
+		}
+		_, err := uploadName(opts, filename)
+		return err
+	}
+
+	for _, header := range headers {
+		if !extract.IsArchive(header.Filename) {
+			if err := check(header.Filename); err != nil {
+				return fmt.Errorf("%s: %w", header.Filename, err)
+			}
+			continue
+		}
+		file, err := header.Open()
+		if err != nil {
+			return err
+		}
+		err = extract.Walk(header.Filename, file, header.Size, archiveLimits, func(e extract.Entry) error {
+			if err := check(path.Base(e.Name)); err != nil {
+				return err
+			}
+			// Reading the entry enforces the limits on its real size.
+			_, err := io.Copy(io.Discard, e.Body)
+			return err
+		})
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", header.Filename, err)
+		}
+	}
+	return nil
+}
+
+// isArchiveError reports whether err was caused by an archive the client sent.
+func isArchiveError(err error) bool {
+	return errors.Is(err, extract.ErrUnsafePath) || errors.Is(err, extract.ErrTooManyEntries) ||
+		errors.Is(err, extract.ErrTooLarge) || errors.Is(err, extract.ErrInvalidArchive) ||
+		errors.Is(err, errInvalidFilename)
+}
+
+// lookupBatch loads the batch named in the request path. Batches of other
+// users are reported as not found.
+func lookupBatch(w http.ResponseWriter, r *http.Request) (*auth.Batch, bool) {
+	user, ok := currentUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	id := r.PathValue("id")
+	batch, err := auth.GetBatch(id)
+	if errors.Is(err, auth.ErrBatchNotFound) || (err == nil && !user.CanAccess(batch.Owner)) {
+		http.Error(w, "Batch not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to get batch %s: %v", id, err)
+		http.Error(w, "Unable to get batch", http.StatusInternalServerError)
+		return nil, false
+	}
+	return batch, true
+}
+
+// @Summary Get Batch
+// @Description Returns the detection progress of a batch upload and the detections found so far, aggregated by class.
+// @Produce json
+// @Param id path string true "Batch ID"
+// @Success 200 {object} batchResponse
+// @Failure 404 {string} string "Batch not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /api/v1/batches/{id} [get]
+func (a *App) getBatch(w http.ResponseWriter, r *http.Request) {
+	batch, ok := lookupBatch(w, r)
+	if !ok {
+		return
+	}
+	progress, err := auth.GetBatchProgress(batch.ID, terminalJobStates)
+	if err != nil {
+		log.Printf("Failed to get progress of batch %s: %v", batch.ID, err)
+		http.Error(w, "Unable to get batch", http.StatusInternalServerError)
+		return
+	}
+	summary, err := auth.GetBatchSummary(batch.ID)
+	if err != nil {
+		log.Printf("Failed to summarize batch %s: %v", batch.ID, err)
+		http.Error(w, "Unable to get batch", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, batchResponse{Batch: batch, Progress: progress, Summary: summary})
+}
+
+// notifyBatchDone tells the owner once the last job of a batch finished.
+func (a *App) notifyBatchDone(record *auth.File) {
+	progress, err := auth.GetBatchProgress(record.BatchID, terminalJobStates)
+	if err != nil {
+		log.Printf("Failed to get progress of batch %s: %v", record.BatchID, err)
+		return
+	}
+	if progress.Done < progress.Total {
+		return
+	}
+	a.notifyUser(record.Owner, fmt.Sprintf("Batch %s finished: %d of %d files succeeded",
+		record.BatchID, progress.States[string(kubeapi.JobSucceeded)], progress.Total))
+}
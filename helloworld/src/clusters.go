@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	auth "helloworld/db"
+	"helloworld/kubeapi"
+	"helloworld/localexec"
+)
+
+const trackerResync = 10 * time.Minute
+
+// newClusterPool connects to the clusters listed in CLUSTERS_FILE. Without
+// it detector Jobs run in a single cluster found through opts, created
+// directly or through DetectionJobs depending on EXECUTOR, or locally when
+// EXECUTOR is "local". Local executors work on the storage directory dataDir.
+func newClusterPool(opts kubeapi.ClientOptions, dataDir string) (*kubeapi.Pool, error) {
+	stub, err := strconv.ParseBool(getenv("LOCAL_EXECUTOR_STUB", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LOCAL_EXECUTOR_STUB: %w", err)
+	}
+	cfg := &kubeapi.PoolConfig{
+		Strategy: kubeapi.StrategyCapacity,
+		Clusters: []kubeapi.ClusterConfig{{
+			Name:      "default",
+			Executor:  getenv("EXECUTOR", kubeapi.ExecutorKubernetes),
+			Stub:      stub,
+			Namespace: "detector",
+			PVC:       "detector-pvc",
+		}},
+	}
+	filename := os.Getenv("CLUSTERS_FILE")
+	if filename != "" {
+		var err error
+		if cfg, err = kubeapi.LoadPoolConfig(filename); err != nil {
+			return nil, err
+		}
+	}
+
+	var clusters []*kubeapi.Cluster
+	for _, cc := range cfg.Clusters {
+		switch cc.Executor {
+		case kubeapi.ExecutorLocal:
+			e := localexec.New(cc.Name, dataDir, cc.Stub)
+			clusters = append(clusters, &kubeapi.Cluster{ClusterConfig: cc, Executor: e, Tracker: e})
+			continue
+		case kubeapi.ExecutorKubernetes, kubeapi.ExecutorDetectionJob:
+		default:
+			return nil, fmt.Errorf("unknown EXECUTOR %q", cc.Executor)
+		}
+
+		clientOpts := opts
+		if filename != "" {
+			clientOpts = cc.ClientOptions()
+		}
+		kc, err := kubeapi.NewKubeClient(clientOpts)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", cc.Name, err)
+		}
+		// The controller names the Jobs of DetectionJobs after them, so they
+		// are tracked like the Jobs the server creates itself.
+		var executor kubeapi.Executor = kc
+		if cc.Executor == kubeapi.ExecutorDetectionJob {
+			executor = kubeapi.DetectionJobClient{KubeClient: kc}
+		}
+		clusters = append(clusters, &kubeapi.Cluster{
+			ClusterConfig: cc,
+			Executor:      executor,
+			Tracker:       kubeapi.NewJobTracker(cc.Name, kc.Clientset, cc.Namespace, trackerResync),
+		})
+	}
+	return kubeapi.NewPool(cfg.Strategy, clusters), nil
+}
+
+// runTrackers follows the detector Jobs of every cluster. Clusters take
+// jobs once their tracker has synced, so an unreachable cluster does not
+// hold up the others.
+func (a *App) runTrackers(ctx context.Context) {
+	for _, c := range a.Clusters.Clusters() {
+		c.Tracker.OnChange(a.handleJobStatus)
+		go func() {
+			if err := c.Tracker.Run(ctx); err != nil {
+				log.Printf("Failed to start job tracker for cluster %s: %v", c.Name, err)
+			}
+		}()
+	}
+}
+
+// jobCluster returns the cluster job was dispatched to.
+func (a *App) jobCluster(job *auth.Job) (*kubeapi.Cluster, error) {
+	c, ok := a.Clusters.Get(job.Cluster)
+	if !ok {
+		return nil, fmt.Errorf("job %s ran in unknown cluster %q", job.ID, job.Cluster)
+	}
+	return c, nil
+}
+
+// deleteClusterJob deletes the cluster Job called name that ran job.
+func (a *App) deleteClusterJob(job *auth.Job, name string) error {
+	c, err := a.jobCluster(job)
+	if err != nil {
+		return err
+	}
+	return c.Executor.DeleteJob(name, c.Namespace)
+}
+
+// startJob creates the cluster Job of job in the cluster the pool picks for
+// its model and records that cluster in job. Unreachable clusters are
+// skipped in favour of the next candidate.
+func (a *App) startJob(ctx context.Context, record *auth.File, job *auth.Job) error {
+	model, err := a.Models.Get(job.Model)
+	if err != nil {
+		return err
+	}
+	inFlight, err := auth.CountJobsByCluster()
+	if err != nil {
+		return err
+	}
+
+	tried := map[string]bool{}
+	for {
+		cluster, err := a.Clusters.Select(model.ClusterSelector, inFlight, tried)
+		if err != nil {
+			return err
+		}
+		tried[cluster.Name] = true
+
+		// Recording the cluster first makes the job count against its
+		// capacity while it is created.
+		if err := auth.SetJobCluster(job.ID, cluster.Name); err != nil {
+			return err
+		}
+		spec, err := a.jobSpec(ctx, cluster, record, job)
+		if err != nil {
+			return err
+		}
+		jobName, err := cluster.Executor.CreateJob(spec)
+		if err == nil {
+			job.Cluster, job.JobName = cluster.Name, jobName
+			return nil
+		}
+		if !a.Clusters.MarkUnreachable(cluster, err) {
+			return err
+		}
+		log.Printf("Cluster %s is unreachable, dispatching job %s elsewhere: %v", cluster.Name, job.ID, err)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"helloworld/controller"
+	"helloworld/kubeapi"
+)
+
+// runController runs the DetectionJob controller for CONTROLLER_NAMESPACE
+// until the process is stopped. It loads the model registry the same way
+// as the server, see newModelRegistry.
+func runController(opts kubeapi.ClientOptions) {
+	workers, err := strconv.Atoi(getenv("CONTROLLER_WORKERS", "2"))
+	if err != nil || workers < 1 {
+		log.Fatalf("Invalid CONTROLLER_WORKERS %q", getenv("CONTROLLER_WORKERS", ""))
+	}
+	registry, err := newModelRegistry()
+	if err != nil {
+		log.Fatalf("Failed to load detection models: %v", err)
+	}
+	kc, err := kubeapi.NewKubeClient(opts)
+	if err != nil {
+		log.Fatalf("Failed to connect to Kubernetes: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	c := controller.New(kc, registry, getenv("CONTROLLER_NAMESPACE", "detector"), trackerResync)
+	if err := c.Run(ctx, workers); err != nil {
+		log.Fatalf("DetectionJob controller failed: %v", err)
+	}
+}
@@ -0,0 +1,356 @@
+// Package controller reconciles DetectionJob objects into detector Jobs and
+// reports the progress of those Jobs in the DetectionJob status.
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"helloworld/kubeapi"
+	"helloworld/models"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Controller watches the DetectionJobs of one namespace together with the
+// Jobs and pods it created for them.
+type Controller struct {
+	kc        *kubeapi.KubeClient
+	models    *models.Registry
+	namespace string
+
+	dynamicFactory dynamicinformer.DynamicSharedInformerFactory
+	factory        informers.SharedInformerFactory
+	djLister       cache.GenericNamespaceLister
+	jobLister      batchlisters.JobNamespaceLister
+	podLister      corelisters.PodNamespaceLister
+	queue          workqueue.TypedRateLimitingInterface[string]
+}
+
+// New returns a controller for the DetectionJobs in namespace that resolves
+// their models against registry.
+func New(kc *kubeapi.KubeClient, registry *models.Registry, namespace string, resync time.Duration) *Controller {
+	dynamicFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(kc.Dynamic, resync, namespace, nil)
+	factory := informers.NewSharedInformerFactoryWithOptions(kc.Clientset, resync,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = labels.SelectorFromSet(labels.Set{kubeapi.LabelApp: kubeapi.AppName}).String()
+		}),
+	)
+
+	djInformer := dynamicFactory.ForResource(kubeapi.DetectionJobResource)
+	c := &Controller{
+		kc:             kc,
+		models:         registry,
+		namespace:      namespace,
+		dynamicFactory: dynamicFactory,
+		factory:        factory,
+		djLister:       djInformer.Lister().ByNamespace(namespace),
+		jobLister:      factory.Batch().V1().Jobs().Lister().Jobs(namespace),
+		podLister:      factory.Core().V1().Pods().Lister().Pods(namespace),
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "detectionjobs"},
+		),
+	}
+
+	djInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueue,
+		UpdateFunc: func(_, obj interface{}) { c.enqueue(obj) },
+	})
+	// Jobs and pods wake the DetectionJob they belong to, which shares the
+	// name of the Job.
+	factory.Batch().V1().Jobs().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueOwner,
+		UpdateFunc: func(_, obj interface{}) { c.enqueueOwner(obj) },
+		DeleteFunc: c.enqueueOwner,
+	})
+	factory.Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueOwner,
+		UpdateFunc: func(_, obj interface{}) { c.enqueueOwner(obj) },
+	})
+	return c
+}
+
+func (c *Controller) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		log.Printf("Failed to queue DetectionJob: %v", err)
+		return
+	}
+	c.queue.Add(key)
+}
+
+// enqueueOwner queues the DetectionJob owning a Job, or the Job of a pod.
+func (c *Controller) enqueueOwner(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	switch o := obj.(type) {
+	case *batchv1.Job:
+		if ref := metav1.GetControllerOf(o); ref != nil && ref.Kind == "DetectionJob" {
+			c.queue.Add(o.Namespace + "/" + ref.Name)
+		}
+	case *v1.Pod:
+		if name := o.Labels[batchv1.JobNameLabel]; name != "" {
+			c.queue.Add(o.Namespace + "/" + name)
+		}
+	}
+}
+
+// Run reconciles DetectionJobs with workers goroutines until ctx is done.
+func (c *Controller) Run(ctx context.Context, workers int) error {
+	defer c.queue.ShutDown()
+
+	c.dynamicFactory.Start(ctx.Done())
+	c.factory.Start(ctx.Done())
+	for typ, ok := range c.dynamicFactory.WaitForCacheSync(ctx.Done()) {
+		if !ok {
+			return fmt.Errorf("failed to sync informer cache for %v", typ)
+		}
+	}
+	for typ, ok := range c.factory.WaitForCacheSync(ctx.Done()) {
+		if !ok {
+			return fmt.Errorf("failed to sync informer cache for %v", typ)
+		}
+	}
+	log.Printf("DetectionJob controller started in namespace %s.", c.namespace)
+
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, c.work, time.Second)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (c *Controller) work(ctx context.Context) {
+	for {
+		key, shutdown := c.queue.Get()
+		if shutdown {
+			return
+		}
+		err := c.reconcile(ctx, key)
+		if err != nil {
+			log.Printf("Failed to reconcile DetectionJob %s: %v", key, err)
+			c.queue.AddRateLimited(key)
+		} else {
+			c.queue.Forget(key)
+		}
+		c.queue.Done(key)
+	}
+}
+
+// reconcile creates the Job of the DetectionJob called key once and copies
+// the state of the Job into the DetectionJob status.
+func (c *Controller) reconcile(ctx context.Context, key string) error {
+	_, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	obj, err := c.djLister.Get(name)
+	if apierrors.IsNotFound(err) {
+		// Its Job goes with it through the owner reference.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	dj, err := kubeapi.DetectionJobFromUnstructured(obj.(*unstructured.Unstructured))
+	if err != nil {
+		return err
+	}
+	// Conditions are updated in place, the rest is replaced.
+	status := dj.Status
+	status.Conditions = append([]metav1.Condition(nil), dj.Status.Conditions...)
+	status.ObservedGeneration = dj.Generation
+
+	job, err := c.jobLister.Get(name)
+	switch {
+	case apierrors.IsNotFound(err) && status.JobName == "":
+		if status.Phase.Terminal() {
+			break
+		}
+		if err := c.createJob(ctx, dj, &status); err != nil {
+			return err
+		}
+	case apierrors.IsNotFound(err):
+		if status.Phase.Terminal() {
+			break
+		}
+		// The cache may not have seen a Job created moments ago.
+		_, err := c.kc.Clientset.BatchV1().Jobs(dj.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err == nil {
+			return nil
+		}
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		setFailed(&status, kubeapi.ReasonLost, "detector job disappeared from the cluster")
+	case err != nil:
+		return err
+	case !metav1.IsControlledBy(job, dj):
+		if status.Phase == "" {
+			setInvalid(&status, fmt.Sprintf("job %s exists and belongs to something else", name))
+		}
+	default:
+		if err := c.observeJob(dj, job, &status); err != nil {
+			return err
+		}
+	}
+
+	if equality.Semantic.DeepEqual(status, dj.Status) {
+		return nil
+	}
+	dj.Status = status
+	u, err := dj.Unstructured()
+	if err != nil {
+		return err
+	}
+	_, err = c.kc.Dynamic.Resource(kubeapi.DetectionJobResource).Namespace(dj.Namespace).UpdateStatus(ctx, u, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+		// A newer version of the DetectionJob is queued already.
+		return nil
+	}
+	return err
+}
+
+// createJob creates the Job of dj, or records in status why it cannot.
+func (c *Controller) createJob(ctx context.Context, dj *kubeapi.DetectionJob, status *kubeapi.DetectionJobStatus) error {
+	spec, err := dj.JobSpec(c.models)
+	if err != nil {
+		setInvalid(status, err.Error())
+		return nil
+	}
+	job, err := kubeapi.NewDetectorJob(dj.Name, spec)
+	if err != nil {
+		setInvalid(status, err.Error())
+		return nil
+	}
+	job.OwnerReferences = []metav1.OwnerReference{dj.OwnerReference()}
+	// The labels the server put on the DetectionJob, e.g. its owner, carry
+	// over to the Job and its pods.
+	for _, m := range []*metav1.ObjectMeta{&job.ObjectMeta, &job.Spec.Template.ObjectMeta} {
+		inherit(m, dj.ObjectMeta)
+	}
+
+	_, err = c.kc.Clientset.BatchV1().Jobs(dj.Namespace).Create(ctx, job, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	log.Printf("Created job %s for DetectionJob %s/%s", job.Name, dj.Namespace, dj.Name)
+
+	status.JobName = job.Name
+	status.Phase = kubeapi.JobPending
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               kubeapi.ConditionJobCreated,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Created",
+		Message:            "created job " + job.Name,
+		ObservedGeneration: dj.Generation,
+	})
+	setFinished(status, metav1.ConditionFalse, string(kubeapi.JobPending), "")
+	return nil
+}
+
+// inherit adds the labels and annotations of from that m does not set.
+func inherit(m *metav1.ObjectMeta, from metav1.ObjectMeta) {
+	for k, v := range from.Labels {
+		if _, ok := m.Labels[k]; !ok {
+			metav1.SetMetaDataLabel(m, k, v)
+		}
+	}
+	for k, v := range from.Annotations {
+		if _, ok := m.Annotations[k]; !ok {
+			metav1.SetMetaDataAnnotation(m, k, v)
+		}
+	}
+}
+
+// observeJob copies the state of job, the Job of dj, into status.
+func (c *Controller) observeJob(dj *kubeapi.DetectionJob, job *batchv1.Job, status *kubeapi.DetectionJobStatus) error {
+	pods, err := c.podLister.List(labels.SelectorFromSet(labels.Set{batchv1.JobNameLabel: job.Name}))
+	if err != nil {
+		return err
+	}
+	observed := kubeapi.ComputeStatus(job, pods)
+
+	status.JobName = job.Name
+	status.Phase = observed.State
+	status.PodName = observed.PodName
+	status.Reason = observed.Reason
+	status.Message = observed.Message
+	status.ExitCode = observed.ExitCode
+	status.StartTime = optionalTime(observed.StartedAt)
+	status.CompletionTime = optionalTime(observed.FinishedAt)
+	status.Result = ""
+
+	switch {
+	case observed.State == kubeapi.JobSucceeded:
+		status.Result = dj.Spec.Output.Path
+		setFinished(status, metav1.ConditionTrue, string(observed.State), "detection succeeded")
+	case observed.State.Terminal():
+		setFinished(status, metav1.ConditionTrue, observed.Reason, observed.Message)
+	default:
+		setFinished(status, metav1.ConditionFalse, string(observed.State), "")
+	}
+	return nil
+}
+
+// setInvalid marks a DetectionJob whose Job cannot be created as failed.
+func setInvalid(status *kubeapi.DetectionJobStatus, message string) {
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               kubeapi.ConditionJobCreated,
+		Status:             metav1.ConditionFalse,
+		Reason:             kubeapi.ReasonInvalidSpec,
+		Message:            message,
+		ObservedGeneration: status.ObservedGeneration,
+	})
+	setFailed(status, kubeapi.ReasonInvalidSpec, message)
+}
+
+func setFailed(status *kubeapi.DetectionJobStatus, reason, message string) {
+	status.Phase = kubeapi.JobFailed
+	status.Reason = reason
+	status.Message = message
+	if status.CompletionTime == nil {
+		status.CompletionTime = optionalTime(time.Now())
+	}
+	setFinished(status, metav1.ConditionTrue, reason, message)
+}
+
+func setFinished(status *kubeapi.DetectionJobStatus, value metav1.ConditionStatus, reason, message string) {
+	if reason == "" {
+		reason = kubeapi.ReasonUnknown
+	}
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               kubeapi.ConditionFinished,
+		Status:             value,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: status.ObservedGeneration,
+	})
+}
+
+func optionalTime(t time.Time) *metav1.Time {
+	if t.IsZero() {
+		return nil
+	}
+	mt := metav1.NewTime(t)
+	return &mt
+}
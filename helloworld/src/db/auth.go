@@ -1,13 +1,35 @@
 package db
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var jwtKey = []byte("nagyontitkoskulcs")
+var jwtKey []byte
+
+const (
+	tokenLifetime = 24 * time.Hour
+	// minJWTKeyLength is the size of an HS256 key, below which tokens could
+	// be forged by guessing the key.
+	minJWTKeyLength = 32
+)
+
+// SetJWTKey sets the key tokens are signed and verified with. Every replica
+// needs the same key to accept the tokens of the others.
+func SetJWTKey(key []byte) error {
+	if len(key) < minJWTKeyLength {
+		return fmt.Errorf("signing key must be at least %d bytes, got %d", minJWTKeyLength, len(key))
+	}
+	jwtKey = key
+	return nil
+}
 
 func HashPassword(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
@@ -22,8 +44,81 @@ func CheckPasswordHash(password, hash string) bool {
 func GenerateJWT(username string) (string, error) {
 	claims := &jwt.MapClaims{
 		"username": username,
-		"exp":      time.Now().Add(24 * time.Hour).Unix(),
+		"exp":      time.Now().Add(tokenLifetime).Unix(),
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(jwtKey)
 }
+
+const tokenCookieName = "token"
+
+var ErrInvalidToken = errors.New("invalid token")
+
+type contextKey string
+
+const usernameContextKey contextKey = "username"
+
+// ParseJWT verifies an HS256 token issued by GenerateJWT and returns the
+// username it was issued for.
+func ParseJWT(tokenString string) (string, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return jwtKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	username, ok := claims["username"].(string)
+	if !ok || username == "" {
+		return "", fmt.Errorf("%w: missing username claim", ErrInvalidToken)
+	}
+	return username, nil
+}
+
+// tokenFromRequest returns the bearer token of the Authorization header, or
+// the token cookie set by LoginHandler for browser navigation and WebSocket
+// upgrades.
+func tokenFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			return ""
+		}
+		return strings.TrimSpace(token)
+	}
+	if cookie, err := r.Cookie(tokenCookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// RequireAuth rejects requests without a valid token and stores the
+// authenticated username in the request context.
+func RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, err := ParseJWT(tokenFromRequest(r))
+		if err != nil {
+			if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				http.Redirect(w, r, "/static/login.html", http.StatusSeeOther)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="helloworld"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		ctx := context.WithValue(r.Context(), usernameContextKey, username)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireAuthFunc is RequireAuth for plain handler functions.
+func RequireAuthFunc(next http.HandlerFunc) http.Handler {
+	return RequireAuth(next)
+}
+
+// UsernameFromContext returns the username stored by RequireAuth.
+func UsernameFromContext(ctx context.Context) (string, bool) {
+	username, ok := ctx.Value(usernameContextKey).(string)
+	return username, ok
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrBatchNotFound = errors.New("batch not found")
+
+// Batch groups the files of one multi-file or archive upload.
+type Batch struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BatchProgress counts the files of a batch by the state of their latest job.
+type BatchProgress struct {
+	Total  int            `json:"total"`
+	Done   int            `json:"done"`
+	States map[string]int `json:"states"`
+}
+
+// ClassSummary aggregates the detections of one class over a batch.
+type ClassSummary struct {
+	ClassName     string  `json:"class_name"`
+	Detections    int     `json:"detections"`
+	Files         int     `json:"files"`
+	MaxConfidence float64 `json:"max_confidence"`
+}
+
+func CreateBatch(b *Batch) error {
+	return DB.QueryRow(`INSERT INTO batches (id, owner) VALUES ($1, $2) RETURNING created_at`, b.ID, b.Owner).
+		Scan(&b.CreatedAt)
+}
+
+func GetBatch(id string) (*Batch, error) {
+	var b Batch
+	err := DB.QueryRow(`SELECT id, owner, created_at FROM batches WHERE id = $1`, id).
+		Scan(&b.ID, &b.Owner, &b.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrBatchNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// GetBatchProgress reports how many files of the batch reached each state.
+// terminal lists the states that count as done.
+func GetBatchProgress(id string, terminal []string) (*BatchProgress, error) {
+	rows, err := DB.Query(`
+        SELECT COALESCE(j.state, ''), count(*)
+        FROM files f
+        LEFT JOIN LATERAL (
+            SELECT state FROM jobs WHERE jobs.upload_id = f.id ORDER BY created_at DESC LIMIT 1
+        ) j ON true
+        WHERE f.batch_id = $1
+        GROUP BY 1`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := map[string]bool{}
+	for _, state := range terminal {
+		done[state] = true
+	}
+	progress := &BatchProgress{States: map[string]int{}}
+	for rows.Next() {
+		var state string
+		var count int
+		if err := rows.Scan(&state, &count); err != nil {
+			return nil, err
+		}
+		progress.States[state] = count
+		progress.Total += count
+		if done[state] {
+			progress.Done += count
+		}
+	}
+	return progress, rows.Err()
+}
+
+func GetBatchSummary(id string) ([]ClassSummary, error) {
+	rows, err := DB.Query(`
+        SELECT d.class_name, count(*), count(DISTINCT d.upload_id), max(d.confidence)
+        FROM detections d
+        JOIN files f ON f.id = d.upload_id
+        WHERE f.batch_id = $1
+        GROUP BY d.class_name
+        ORDER BY count(*) DESC, d.class_name`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summary := []ClassSummary{}
+	for rows.Next() {
+		var c ClassSummary
+		if err := rows.Scan(&c.ClassName, &c.Detections, &c.Files, &c.MaxConfidence); err != nil {
+			return nil, err
+		}
+		summary = append(summary, c)
+	}
+	return summary, rows.Err()
+}
@@ -9,6 +9,95 @@ import (
 
 var DB *sql.DB
 
+// schema creates the tables, or adds what is missing to existing ones.
+const schema = `
+        CREATE TABLE IF NOT EXISTS users (
+            id SERIAL PRIMARY KEY,
+            username TEXT UNIQUE NOT NULL,
+            password_hash TEXT NOT NULL
+        );
+        ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+        CREATE TABLE IF NOT EXISTS files (
+            id TEXT PRIMARY KEY,
+            owner TEXT NOT NULL,
+            original_name TEXT NOT NULL,
+            stored_path TEXT NOT NULL,
+            size BIGINT NOT NULL,
+            content_type TEXT NOT NULL,
+            sha256 TEXT NOT NULL,
+            created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+        );
+        CREATE INDEX IF NOT EXISTS files_owner_created_at_idx ON files (owner, created_at DESC);
+
+        CREATE TABLE IF NOT EXISTS batches (
+            id TEXT PRIMARY KEY,
+            owner TEXT NOT NULL,
+            created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+        );
+        ALTER TABLE files ADD COLUMN IF NOT EXISTS batch_id TEXT REFERENCES batches (id);
+        CREATE INDEX IF NOT EXISTS files_batch_id_idx ON files (batch_id);
+        CREATE INDEX IF NOT EXISTS files_sha256_idx ON files (sha256);
+        ALTER TABLE files ADD COLUMN IF NOT EXISTS results_dir TEXT NOT NULL DEFAULT '';
+
+        CREATE TABLE IF NOT EXISTS jobs (
+            id TEXT PRIMARY KEY,
+            upload_id TEXT NOT NULL,
+            owner TEXT NOT NULL,
+            filename TEXT NOT NULL,
+            job_name TEXT NOT NULL DEFAULT '',
+            pod_name TEXT NOT NULL DEFAULT '',
+            model TEXT NOT NULL,
+            state TEXT NOT NULL,
+            created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+            updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+            started_at TIMESTAMPTZ,
+            finished_at TIMESTAMPTZ,
+            exit_code INTEGER,
+            error TEXT NOT NULL DEFAULT ''
+        );
+        ALTER TABLE jobs ADD COLUMN IF NOT EXISTS reused_from TEXT NOT NULL DEFAULT '';
+        ALTER TABLE jobs ADD COLUMN IF NOT EXISTS conf DOUBLE PRECISION NOT NULL DEFAULT 0;
+        ALTER TABLE jobs ADD COLUMN IF NOT EXISTS iou DOUBLE PRECISION NOT NULL DEFAULT 0;
+        ALTER TABLE jobs ADD COLUMN IF NOT EXISTS img_size INTEGER NOT NULL DEFAULT 0;
+        ALTER TABLE jobs ADD COLUMN IF NOT EXISTS priority INTEGER NOT NULL DEFAULT 0;
+        ALTER TABLE jobs ADD COLUMN IF NOT EXISTS reason TEXT NOT NULL DEFAULT '';
+        ALTER TABLE jobs ADD COLUMN IF NOT EXISTS attempts JSONB NOT NULL DEFAULT '[]';
+        ALTER TABLE jobs ADD COLUMN IF NOT EXISTS not_before TIMESTAMPTZ;
+        ALTER TABLE jobs ADD COLUMN IF NOT EXISTS cluster TEXT NOT NULL DEFAULT '';
+        CREATE INDEX IF NOT EXISTS jobs_queue_idx ON jobs (priority DESC, created_at) WHERE state = 'Queued';
+        CREATE INDEX IF NOT EXISTS jobs_upload_id_idx ON jobs (upload_id);
+        CREATE INDEX IF NOT EXISTS jobs_owner_created_at_idx ON jobs (owner, created_at DESC);
+
+        CREATE TABLE IF NOT EXISTS detections (
+            id SERIAL PRIMARY KEY,
+            upload_id TEXT NOT NULL,
+            frame INTEGER NOT NULL DEFAULT 0,
+            class_id INTEGER NOT NULL,
+            class_name TEXT NOT NULL,
+            x_center DOUBLE PRECISION NOT NULL,
+            y_center DOUBLE PRECISION NOT NULL,
+            width DOUBLE PRECISION NOT NULL,
+            height DOUBLE PRECISION NOT NULL,
+            confidence DOUBLE PRECISION NOT NULL,
+            created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+        );
+        CREATE INDEX IF NOT EXISTS detections_upload_id_idx ON detections (upload_id);
+        CREATE INDEX IF NOT EXISTS detections_class_confidence_idx ON detections (class_name, confidence, upload_id);
+        CREATE INDEX IF NOT EXISTS files_created_at_idx ON files (created_at);
+
+        CREATE TABLE IF NOT EXISTS outbox (
+            id BIGSERIAL PRIMARY KEY,
+            event_type TEXT NOT NULL,
+            key TEXT NOT NULL,
+            payload BYTEA NOT NULL,
+            created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+            sent_at TIMESTAMPTZ
+        );
+        CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (id) WHERE sent_at IS NULL;
+        CREATE INDEX IF NOT EXISTS outbox_sent_at_idx ON outbox (sent_at);
+`
+
 func InitDB() {
 	var err error
 	connStr := "host=postgres user=postgres password=secret dbname=authdb sslmode=disable"
@@ -17,13 +106,7 @@ func InitDB() {
 		log.Fatal(err)
 	}
 
-	_, err = DB.Exec(`
-        CREATE TABLE IF NOT EXISTS users (
-            id SERIAL PRIMARY KEY,
-            username TEXT UNIQUE NOT NULL,
-            password_hash TEXT NOT NULL
-        );
-    `)
+	_, err = DB.Exec(schema)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,79 @@
+package db
+
+import "time"
+
+type Detection struct {
+	ID         int       `json:"id"`
+	UploadID   string    `json:"upload_id"`
+	Frame      int       `json:"frame"`
+	ClassID    int       `json:"class_id"`
+	ClassName  string    `json:"class_name"`
+	XCenter    float64   `json:"x_center"`
+	YCenter    float64   `json:"y_center"`
+	Width      float64   `json:"width"`
+	Height     float64   `json:"height"`
+	Confidence float64   `json:"confidence"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// SaveDetections replaces the stored detections of uploadID.
+func SaveDetections(uploadID string, detections []Detection) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM detections WHERE upload_id = $1`, uploadID); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`
+        INSERT INTO detections (upload_id, frame, class_id, class_name, x_center, y_center, width, height, confidence)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, d := range detections {
+		_, err := stmt.Exec(uploadID, d.Frame, d.ClassID, d.ClassName, d.XCenter, d.YCenter, d.Width, d.Height, d.Confidence)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// CopyDetections links the detections found in the upload from to the upload
+// to, which has the same content.
+func CopyDetections(from, to string) error {
+	_, err := DB.Exec(`
+        INSERT INTO detections (upload_id, frame, class_id, class_name, x_center, y_center, width, height, confidence)
+        SELECT $2, frame, class_id, class_name, x_center, y_center, width, height, confidence
+        FROM detections WHERE upload_id = $1`, from, to)
+	return err
+}
+
+func ListDetections(uploadID string) ([]Detection, error) {
+	rows, err := DB.Query(`
+        SELECT id, upload_id, frame, class_id, class_name, x_center, y_center, width, height, confidence, created_at
+        FROM detections
+        WHERE upload_id = $1
+        ORDER BY frame, confidence DESC`, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	detections := []Detection{}
+	for rows.Next() {
+		var d Detection
+		err := rows.Scan(&d.ID, &d.UploadID, &d.Frame, &d.ClassID, &d.ClassName,
+			&d.XCenter, &d.YCenter, &d.Width, &d.Height, &d.Confidence, &d.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		detections = append(detections, d)
+	}
+	return detections, rows.Err()
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrFileNotFound = errors.New("file not found")
+
+type File struct {
+	ID           string    `json:"id"`
+	Owner        string    `json:"owner"`
+	OriginalName string    `json:"original_name"`
+	StoredPath   string    `json:"-"`
+	ResultsDir   string    `json:"-"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"content_type"`
+	SHA256       string    `json:"sha256"`
+	BatchID      string    `json:"batch_id,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+const fileColumns = `id, owner, original_name, stored_path, results_dir, size, content_type, sha256, COALESCE(batch_id, ''), created_at`
+
+func scanFile(row rowScanner) (*File, error) {
+	var f File
+	err := row.Scan(&f.ID, &f.Owner, &f.OriginalName, &f.StoredPath, &f.ResultsDir, &f.Size, &f.ContentType, &f.SHA256, &f.BatchID, &f.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func CreateFile(f *File) error {
+	return DB.QueryRow(`
+        INSERT INTO files (id, owner, original_name, stored_path, results_dir, size, content_type, sha256, batch_id)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''))
+        RETURNING created_at`,
+		f.ID, f.Owner, f.OriginalName, f.StoredPath, f.ResultsDir, f.Size, f.ContentType, f.SHA256, f.BatchID,
+	).Scan(&f.CreatedAt)
+}
+
+func GetFile(id string) (*File, error) {
+	f, err := scanFile(DB.QueryRow(`SELECT `+fileColumns+` FROM files WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrFileNotFound
+	}
+	return f, err
+}
+
+// FindProcessedFile returns the most recently processed upload whose content
+// hashes to sha256 and whose detection with cfg succeeded.
+func FindProcessedFile(sha256 string, cfg JobConfig) (*File, error) {
+	f, err := scanFile(DB.QueryRow(`
+        SELECT `+fileColumns+` FROM files
+        WHERE id = (
+            SELECT j.upload_id FROM jobs j
+            JOIN files f ON f.id = j.upload_id
+            WHERE f.sha256 = $1 AND j.model = $2 AND j.conf = $3 AND j.iou = $4 AND j.img_size = $5
+                AND j.state = 'Succeeded'
+            ORDER BY j.finished_at DESC
+            LIMIT 1
+        )`, sha256, cfg.Model, cfg.Conf, cfg.IoU, cfg.ImgSize))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrFileNotFound
+	}
+	return f, err
+}
+
+// ListFiles returns the files of owner, newest first. An empty owner lists
+// every file.
+func ListFiles(owner string) ([]File, error) {
+	rows, err := DB.Query(`
+        SELECT `+fileColumns+` FROM files
+        WHERE $1 = '' OR owner = $1
+        ORDER BY created_at DESC`, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []File{}
+	for rows.Next() {
+		f, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, *f)
+	}
+	return files, rows.Err()
+}
@@ -0,0 +1,62 @@
+package db
+
+import "database/sql"
+
+// ListStartedJobs returns the jobs that were handed to the cluster and have
+// not finished yet.
+func ListStartedJobs() ([]Job, error) {
+	rows, err := DB.Query(`
+        SELECT ` + jobColumns + ` FROM jobs
+        WHERE state IN ('Pending', 'Running') AND job_name <> ''`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+// FailLostJob marks job id failed when it still waits for the cluster Job
+// jobName. It reports whether the job was updated; only then are the outbox
+// messages recorded.
+func FailLostJob(id, jobName, reason, errMsg string, outbox ...OutboxMessage) (bool, error) {
+	return withOutbox(outbox, func(tx *sql.Tx) (bool, error) {
+		res, err := tx.Exec(`
+            UPDATE jobs SET state = 'Failed', reason = $3, error = $4, updated_at = now(),
+                finished_at = COALESCE(finished_at, now())
+            WHERE id = $1 AND job_name = $2 AND state IN ('Pending', 'Running')`,
+			id, jobName, reason, errMsg)
+		if err != nil {
+			return false, err
+		}
+		n, err := res.RowsAffected()
+		return n > 0, err
+	})
+}
+
+// ListStoredPaths returns the distinct storage keys uploads are stored at.
+func ListStoredPaths() ([]string, error) {
+	rows, err := DB.Query(`SELECT DISTINCT stored_path FROM files`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, rows.Err()
+}
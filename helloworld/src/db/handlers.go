@@ -1,8 +1,11 @@
 package db
 
 import (
+	"database/sql"
 	"encoding/json"
 	"net/http"
+	"strings"
+	"time"
 )
 
 type Credentials struct {
@@ -10,9 +13,22 @@ type Credentials struct {
 	Password string `json:"password"`
 }
 
+var (
+	// OnRegister, when set, returns the outbox messages announcing a new
+	// user, which are recorded along with it.
+	OnRegister func(username string) []OutboxMessage
+	// OnRegistered, when set, is called once a new user is committed, e.g.
+	// to have the outbox messages relayed without waiting for the next poll.
+	OnRegistered func(username string)
+)
+
 func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	var creds Credentials
 	json.NewDecoder(r.Body).Decode(&creds)
+	if strings.TrimSpace(creds.Username) == "" {
+		http.Error(w, "Username is required", http.StatusBadRequest)
+		return
+	}
 
 	hash, err := HashPassword(creds.Password)
 	if err != nil {
@@ -20,11 +36,21 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = DB.Exec("INSERT INTO users (username, password_hash) VALUES ($1, $2)", creds.Username, hash)
+	var outbox []OutboxMessage
+	if OnRegister != nil {
+		outbox = OnRegister(creds.Username)
+	}
+	_, err = withOutbox(outbox, func(tx *sql.Tx) (bool, error) {
+		_, err := tx.Exec("INSERT INTO users (username, password_hash) VALUES ($1, $2)", creds.Username, hash)
+		return err == nil, err
+	})
 	if err != nil {
 		http.Error(w, "User already exists or error", http.StatusBadRequest)
 		return
 	}
+	if OnRegistered != nil {
+		OnRegistered(creds.Username)
+	}
 
 	w.WriteHeader(http.StatusCreated)
 }
@@ -51,5 +77,25 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	http.SetCookie(w, &http.Cookie{
+		Name:     tokenCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(tokenLifetime),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
 	json.NewEncoder(w).Encode(map[string]string{"token": token})
 }
+
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     tokenCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
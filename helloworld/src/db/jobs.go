@@ -0,0 +1,281 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobFinished is returned when changing the state of a job that
+	// already finished.
+	ErrJobFinished = errors.New("job already finished")
+)
+
+type Job struct {
+	ID       string `json:"id"`
+	UploadID string `json:"upload_id"`
+	Owner    string `json:"owner"`
+	Filename string `json:"filename"`
+	JobName  string `json:"job_name,omitempty"`
+	PodName  string `json:"pod_name,omitempty"`
+	// Cluster names the cluster the job was dispatched to.
+	Cluster string `json:"cluster,omitempty"`
+	JobConfig
+	Priority   int        `json:"priority"`
+	State      string     `json:"state"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	ExitCode   *int32     `json:"exit_code,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Reason     string     `json:"reason,omitempty"`
+	ReusedFrom string     `json:"reused_from,omitempty"`
+	// Attempts lists the failed runs of the job, oldest first.
+	Attempts []JobAttempt `json:"attempts"`
+	// RetryAt is when a job queued for another attempt may run again.
+	RetryAt *time.Time `json:"retry_at,omitempty"`
+}
+
+// JobAttempt records one failed run of a job.
+type JobAttempt struct {
+	Cluster    string     `json:"cluster,omitempty"`
+	JobName    string     `json:"job_name"`
+	PodName    string     `json:"pod_name,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time  `json:"finished_at"`
+	ExitCode   *int32     `json:"exit_code,omitempty"`
+	Reason     string     `json:"reason"`
+	Message    string     `json:"message,omitempty"`
+	Transient  bool       `json:"transient"`
+}
+
+// JobConfig selects the model and settings a job runs the detector with.
+type JobConfig struct {
+	Model   string  `json:"model"`
+	Conf    float64 `json:"conf"`
+	IoU     float64 `json:"iou"`
+	ImgSize int     `json:"img_size"`
+}
+
+// JobUpdate carries the fields observed on the cluster for a running job.
+type JobUpdate struct {
+	JobName    string
+	PodName    string
+	State      string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	ExitCode   *int32
+	Error      string
+	Reason     string
+}
+
+const jobColumns = `id, upload_id, owner, filename, job_name, pod_name, cluster, model, conf, iou, img_size, priority, state,
+	created_at, updated_at, started_at, finished_at, exit_code, error, reason, reused_from, attempts, not_before`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanJob(row rowScanner) (*Job, error) {
+	var job Job
+	var startedAt, finishedAt, retryAt sql.NullTime
+	var exitCode sql.NullInt32
+	var attempts []byte
+	err := row.Scan(&job.ID, &job.UploadID, &job.Owner, &job.Filename, &job.JobName, &job.PodName, &job.Cluster,
+		&job.Model, &job.Conf, &job.IoU, &job.ImgSize, &job.Priority, &job.State, &job.CreatedAt, &job.UpdatedAt, &startedAt, &finishedAt, &exitCode, &job.Error, &job.Reason, &job.ReusedFrom, &attempts, &retryAt)
+	if err != nil {
+		return nil, err
+	}
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	if exitCode.Valid {
+		job.ExitCode = &exitCode.Int32
+	}
+	if retryAt.Valid {
+		job.RetryAt = &retryAt.Time
+	}
+	if err := json.Unmarshal(attempts, &job.Attempts); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// CreateJob records job and the outbox messages announcing it.
+func CreateJob(job *Job, outbox ...OutboxMessage) error {
+	_, err := withOutbox(outbox, func(tx *sql.Tx) (bool, error) {
+		err := tx.QueryRow(`
+            INSERT INTO jobs (id, upload_id, owner, filename, job_name, model, conf, iou, img_size, priority, state, reused_from, finished_at)
+            VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+            RETURNING created_at, updated_at`,
+			job.ID, job.UploadID, job.Owner, job.Filename, job.JobName, job.Model, job.Conf, job.IoU, job.ImgSize,
+			job.Priority, job.State, job.ReusedFrom, job.FinishedAt,
+		).Scan(&job.CreatedAt, &job.UpdatedAt)
+		return err == nil, err
+	})
+	return err
+}
+
+func GetJob(id string) (*Job, error) {
+	job, err := scanJob(DB.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	return job, err
+}
+
+// GetJobByUpload returns the most recent job that processed uploadID.
+func GetJobByUpload(uploadID string) (*Job, error) {
+	job, err := scanJob(DB.QueryRow(`
+        SELECT `+jobColumns+` FROM jobs
+        WHERE upload_id = $1
+        ORDER BY created_at DESC
+        LIMIT 1`, uploadID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	return job, err
+}
+
+// ListJobs returns the jobs of owner, newest first. An empty owner lists every job.
+func ListJobs(owner string) ([]Job, error) {
+	rows, err := DB.Query(`
+        SELECT `+jobColumns+` FROM jobs
+        WHERE $1 = '' OR owner = $1
+        ORDER BY created_at DESC`, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+// UpdateJobByUpload records the latest cluster-side state of the job
+// processing uploadID and reports whether the job entered u.State with this
+// update, so that replicas observing the same change act on it only once.
+// Only then are the outbox messages recorded. Cancelled and queued jobs, and
+// jobs whose cluster Job has since been replaced, are left untouched.
+func UpdateJobByUpload(uploadID string, u JobUpdate, outbox ...OutboxMessage) (bool, error) {
+	return withOutbox(outbox, func(tx *sql.Tx) (bool, error) {
+		// Locking the row makes a concurrent update see the state written here.
+		var previous string
+		err := tx.QueryRow(`
+            UPDATE jobs SET
+                job_name = COALESCE(NULLIF($2, ''), jobs.job_name),
+                pod_name = COALESCE(NULLIF($3, ''), jobs.pod_name),
+                state = $4,
+                started_at = COALESCE($5, jobs.started_at),
+                finished_at = COALESCE($6, jobs.finished_at),
+                exit_code = COALESCE($7, jobs.exit_code),
+                error = $8,
+                reason = $9,
+                updated_at = now()
+            FROM (SELECT id, state FROM jobs WHERE upload_id = $1 FOR UPDATE) old
+            WHERE jobs.id = old.id AND jobs.state NOT IN ('Cancelled', 'Queued') AND (jobs.job_name = '' OR jobs.job_name = $2)
+            RETURNING old.state`,
+			uploadID, u.JobName, u.PodName, u.State, nullTime(u.StartedAt), nullTime(u.FinishedAt), u.ExitCode, u.Error, u.Reason).Scan(&previous)
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return previous != u.State, nil
+	})
+}
+
+// AddJobAttempt appends a failed run to the history of job id.
+func AddJobAttempt(id string, attempt JobAttempt) error {
+	data, err := json.Marshal(attempt)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec(`UPDATE jobs SET attempts = attempts || jsonb_build_array($2::jsonb) WHERE id = $1`, id, string(data))
+	return err
+}
+
+// RetryJob records the failed run attempt of job id and queues the job
+// again, to be dispatched no earlier than at. It reports whether the job was
+// queued: jobs that finished, were queued already or have since been
+// dispatched as another cluster Job than attempt.JobName are left untouched,
+// so that only one replica observing the failure retries it.
+func RetryJob(id string, attempt JobAttempt, at time.Time) (bool, error) {
+	data, err := json.Marshal(attempt)
+	if err != nil {
+		return false, err
+	}
+	res, err := DB.Exec(`
+        UPDATE jobs SET
+            state = 'Queued',
+            job_name = '',
+            pod_name = '',
+            cluster = '',
+            started_at = NULL,
+            finished_at = NULL,
+            exit_code = NULL,
+            error = $3,
+            reason = $4,
+            attempts = attempts || jsonb_build_array($2::jsonb),
+            not_before = $5,
+            updated_at = now()
+        WHERE id = $1 AND (job_name = '' OR job_name = $6)
+          AND state NOT IN ('Queued', 'Succeeded', 'Failed', 'TimedOut', 'Cancelled')`,
+		id, string(data), attempt.Message, attempt.Reason, at, attempt.JobName)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SetJobCluster records the cluster job id is being dispatched to.
+func SetJobCluster(id, cluster string) error {
+	_, err := DB.Exec(`UPDATE jobs SET cluster = $2, updated_at = now() WHERE id = $1`, id, cluster)
+	return err
+}
+
+func SetJobName(id, jobName string) error {
+	_, err := DB.Exec(`UPDATE jobs SET job_name = $2, updated_at = now() WHERE id = $1`, id, jobName)
+	return err
+}
+
+// SetJobState finishes job id in state and records the outbox messages
+// announcing it. Jobs that already finished keep their state, and
+// ErrJobFinished is returned for them.
+func SetJobState(id, state, errMsg string, outbox ...OutboxMessage) error {
+	changed, err := withOutbox(outbox, func(tx *sql.Tx) (bool, error) {
+		res, err := tx.Exec(`
+            UPDATE jobs SET state = $2, error = $3, updated_at = now(),
+                finished_at = COALESCE(finished_at, now())
+            WHERE id = $1 AND state NOT IN ('Succeeded', 'Failed', 'TimedOut', 'Cancelled')`, id, state, errMsg)
+		if err != nil {
+			return false, err
+		}
+		n, err := res.RowsAffected()
+		return n > 0, err
+	})
+	if err == nil && !changed {
+		return ErrJobFinished
+	}
+	return err
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
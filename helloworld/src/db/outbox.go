@@ -0,0 +1,110 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// outboxLock keeps replicas from relaying the outbox at the same time, which
+// would publish its events twice and out of order.
+const outboxLock = 0x6f757462 // "outb"
+
+// OutboxMessage is an encoded event waiting in the outbox to be published.
+// Events are written to the outbox in the transaction of the change they
+// announce, so they are published if and only if the change commits.
+type OutboxMessage struct {
+	ID        int64
+	EventType string
+	Key       string
+	Payload   []byte
+}
+
+// withOutbox runs change in a transaction and, if change reports that it
+// changed something, records outbox in the same transaction. It returns
+// what change reported.
+func withOutbox(outbox []OutboxMessage, change func(tx *sql.Tx) (bool, error)) (bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	changed, err := change(tx)
+	if err != nil {
+		return false, err
+	}
+	if changed {
+		for _, m := range outbox {
+			_, err := tx.Exec(`INSERT INTO outbox (event_type, key, payload) VALUES ($1, $2, $3)`, m.EventType, m.Key, m.Payload)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+	return changed, tx.Commit()
+}
+
+// RelayOutbox passes the oldest limit unsent messages of the outbox to send,
+// in the order they were recorded, and marks them sent if send succeeds. It
+// returns the number of messages sent. While one replica relays, the calls
+// of the others return without calling send.
+func RelayOutbox(limit int, send func([]OutboxMessage) error) (int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRow(`SELECT pg_try_advisory_xact_lock($1)`, outboxLock).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+	rows, err := tx.Query(`
+        SELECT id, event_type, key, payload FROM outbox
+        WHERE sent_at IS NULL
+        ORDER BY id
+        LIMIT $1`, limit)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var messages []OutboxMessage
+	var ids []int64
+	for rows.Next() {
+		var m OutboxMessage
+		if err := rows.Scan(&m.ID, &m.EventType, &m.Key, &m.Payload); err != nil {
+			return 0, err
+		}
+		messages = append(messages, m)
+		ids = append(ids, m.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(messages) == 0 {
+		return 0, nil
+	}
+
+	if err := send(messages); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`UPDATE outbox SET sent_at = now() WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+		return 0, err
+	}
+	return len(messages), tx.Commit()
+}
+
+// DeleteSentOutbox removes the messages sent before t.
+func DeleteSentOutbox(t time.Time) (int64, error) {
+	res, err := DB.Exec(`DELETE FROM outbox WHERE sent_at < $1`, t)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// queueLock serializes dispatching between server replicas.
+const queueLock = 0x6a6f6271 // "jobq"
+
+// QueueLimits bound how many detection jobs may be dispatched at once.
+type QueueLimits struct {
+	MaxInFlight int
+	MaxPerUser  int
+}
+
+// QueueStats describe the queue from the point of view of one user.
+type QueueStats struct {
+	Queued       int `json:"queued"`
+	InFlight     int `json:"in_flight"`
+	UserQueued   int `json:"user_queued"`
+	UserInFlight int `json:"user_in_flight"`
+}
+
+// ClaimQueuedJob moves the next queued job the limits allow to dispatch to
+// state Pending and returns it. Jobs of higher priority go first; among equal
+// priorities the owner with the fewest jobs in flight wins, then the oldest
+// job. ErrJobNotFound means nothing can be dispatched right now.
+func ClaimQueuedJob(limits QueueLimits) (*Job, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, queueLock); err != nil {
+		return nil, err
+	}
+	var inFlight int
+	if err := tx.QueryRow(`SELECT count(*) FROM jobs WHERE state IN ('Pending', 'Running')`).Scan(&inFlight); err != nil {
+		return nil, err
+	}
+	if limits.MaxInFlight > 0 && inFlight >= limits.MaxInFlight {
+		return nil, ErrJobNotFound
+	}
+
+	job, err := scanJob(tx.QueryRow(`
+        WITH in_flight AS (
+            SELECT owner, count(*) AS n FROM jobs
+            WHERE state IN ('Pending', 'Running')
+            GROUP BY owner
+        ), next AS (
+            SELECT j.id FROM jobs j
+            LEFT JOIN in_flight f ON f.owner = j.owner
+            WHERE j.state = 'Queued' AND ($1 = 0 OR COALESCE(f.n, 0) < $1)
+                AND (j.not_before IS NULL OR j.not_before <= now())
+            ORDER BY j.priority DESC, COALESCE(f.n, 0), j.created_at
+            LIMIT 1
+        )
+        UPDATE jobs SET state = 'Pending', updated_at = now()
+        WHERE id = (SELECT id FROM next)
+        RETURNING `+jobColumns, limits.MaxPerUser))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, tx.Commit()
+}
+
+// RequeueStaleJobs puts jobs back into the queue that were claimed before
+// olderThan but never got a cluster Job, e.g. because the server stopped
+// while dispatching them.
+func RequeueStaleJobs(olderThan time.Time) (int64, error) {
+	res, err := DB.Exec(`
+        UPDATE jobs SET state = 'Queued', cluster = '', updated_at = now()
+        WHERE state = 'Pending' AND job_name = '' AND updated_at < $1`, olderThan)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ReleaseJob puts claimed job id back into the queue, to be dispatched no
+// earlier than at, because no cluster could take it.
+func ReleaseJob(id string, at time.Time) error {
+	_, err := DB.Exec(`
+        UPDATE jobs SET state = 'Queued', cluster = '', not_before = $2, updated_at = now()
+        WHERE id = $1 AND state = 'Pending' AND job_name = ''`, id, at)
+	return err
+}
+
+// CountJobsByCluster returns how many dispatched jobs are in flight on every
+// cluster.
+func CountJobsByCluster() (map[string]int, error) {
+	rows, err := DB.Query(`
+        SELECT cluster, count(*) FROM jobs
+        WHERE state IN ('Pending', 'Running') AND cluster <> ''
+        GROUP BY cluster`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var cluster string
+		var n int
+		if err := rows.Scan(&cluster, &n); err != nil {
+			return nil, err
+		}
+		counts[cluster] = n
+	}
+	return counts, rows.Err()
+}
+
+// GetQueueStats counts queued and dispatched jobs overall and of owner.
+func GetQueueStats(owner string) (*QueueStats, error) {
+	var s QueueStats
+	err := DB.QueryRow(`
+        SELECT
+            count(*) FILTER (WHERE state = 'Queued'),
+            count(*) FILTER (WHERE state IN ('Pending', 'Running')),
+            count(*) FILTER (WHERE state = 'Queued' AND owner = $1),
+            count(*) FILTER (WHERE state IN ('Pending', 'Running') AND owner = $1)
+        FROM jobs
+        WHERE state IN ('Queued', 'Pending', 'Running')`, owner).
+		Scan(&s.Queued, &s.InFlight, &s.UserQueued, &s.UserInFlight)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
@@ -0,0 +1,65 @@
+package db
+
+import "time"
+
+// SearchQuery selects uploads with at least MinCount detections of Class at
+// or above MinConfidence in one image or video frame. Zero From/To leave the
+// upload time unbounded and an empty Owner searches the uploads of every user.
+type SearchQuery struct {
+	Owner         string
+	Class         string
+	MinConfidence float64
+	MinCount      int
+	From          time.Time
+	To            time.Time
+	Limit         int
+	Offset        int
+}
+
+type SearchResult struct {
+	UploadID   string    `json:"upload_id"`
+	Filename   string    `json:"filename"`
+	UploadedAt time.Time `json:"uploaded_at"`
+	// Matches counts the matching detections in every frame.
+	Matches       int     `json:"matches"`
+	MaxConfidence float64 `json:"max_confidence"`
+}
+
+// SearchUploads returns one page of matching uploads, newest first, together
+// with the total number of matches.
+func SearchUploads(q SearchQuery) ([]SearchResult, int, error) {
+	rows, err := DB.Query(`
+        WITH frames AS (
+            SELECT upload_id, frame, count(*) AS matches, max(confidence) AS max_confidence
+            FROM detections
+            WHERE ($1 = '' OR class_name = $1) AND confidence >= $2
+            GROUP BY upload_id, frame
+        )
+        SELECT f.id, f.original_name, f.created_at, sum(fr.matches)::int, max(fr.max_confidence), count(*) OVER ()
+        FROM frames fr
+        JOIN files f ON f.id = fr.upload_id
+        WHERE ($3::timestamptz IS NULL OR f.created_at >= $3)
+          AND ($4::timestamptz IS NULL OR f.created_at < $4)
+          AND ($8 = '' OR f.owner = $8)
+        GROUP BY f.id, f.original_name, f.created_at
+        HAVING max(fr.matches) >= $5
+        ORDER BY f.created_at DESC, f.id
+        LIMIT $6 OFFSET $7`,
+		q.Class, q.MinConfidence, nullTime(q.From), nullTime(q.To), q.MinCount, q.Limit, q.Offset, q.Owner)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	total := 0
+	for rows.Next() {
+		var res SearchResult
+		err := rows.Scan(&res.UploadID, &res.Filename, &res.UploadedAt, &res.Matches, &res.MaxConfidence, &total)
+		if err != nil {
+			return nil, 0, err
+		}
+		results = append(results, res)
+	}
+	return results, total, rows.Err()
+}
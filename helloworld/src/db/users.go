@@ -0,0 +1,32 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+type User struct {
+	ID       int
+	Username string
+	IsAdmin  bool
+}
+
+func GetUser(username string) (*User, error) {
+	var user User
+	err := DB.QueryRow(`SELECT id, username, is_admin FROM users WHERE username = $1`, username).
+		Scan(&user.ID, &user.Username, &user.IsAdmin)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// CanAccess reports whether the user may see objects belonging to owner.
+func (u *User) CanAccess(owner string) bool {
+	return u.IsAdmin || u.Username == owner
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	auth "helloworld/db"
+	"helloworld/yolo"
+)
+
+// collectDetections reads the label files written by the detector for
+// uploadID and stores them in the database.
+func (a *App) collectDetections(ctx context.Context, uploadID string) error {
+	record, err := auth.GetFile(uploadID)
+	if err != nil {
+		return err
+	}
+	if err := a.unpackResults(ctx, record); err != nil {
+		return fmt.Errorf("unpacking results: %w", err)
+	}
+
+	labels, err := a.Storage.List(ctx, path.Join(detectedDir(record), "labels")+"/")
+	if err != nil {
+		return err
+	}
+
+	var detections []auth.Detection
+	for _, obj := range labels {
+		name := path.Base(obj.Key)
+		if path.Ext(name) != ".txt" {
+			continue
+		}
+		frame, ok := yolo.LabelFrame(strings.TrimSuffix(name, ".txt"), path.Base(record.StoredPath))
+		if !ok {
+			continue
+		}
+
+		rc, _, err := a.Storage.Get(ctx, obj.Key)
+		if err != nil {
+			return err
+		}
+		parsed, err := yolo.ParseLabels(rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		for _, d := range parsed {
+			detections = append(detections, auth.Detection{
+				Frame:      frame,
+				ClassID:    d.ClassID,
+				ClassName:  d.ClassName,
+				XCenter:    d.XCenter,
+				YCenter:    d.YCenter,
+				Width:      d.Width,
+				Height:     d.Height,
+				Confidence: d.Confidence,
+			})
+		}
+	}
+	if err := auth.SaveDetections(uploadID, detections); err != nil {
+		return err
+	}
+	log.Printf("Stored %d detections for upload %s", len(detections), uploadID)
+	return nil
+}
+
+// @Summary List Detections
+// @Description Returns the objects detected in an uploaded file.
+// @Produce json
+// @Param id path string true "Upload ID"
+// @Success 200 {array} db.Detection
+// @Failure 404 {string} string "File not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /api/v1/files/{id}/detections [get]
+func (a *App) listDetections(w http.ResponseWriter, r *http.Request) {
+	record, ok := lookupFile(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+
+	detections, err := auth.ListDetections(record.ID)
+	if err != nil {
+		log.Printf("Failed to list detections of upload %s: %v", record.ID, err)
+		http.Error(w, "Unable to list detections", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, detections)
+}
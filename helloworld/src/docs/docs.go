@@ -17,26 +17,292 @@ const docTemplate = `{
     "paths": {
         "/": {
             "post": {
-                "description": "Uploads a file to the server.",
+                "description": "Uploads a file to the server. Several files, or .zip and .tar.gz archives of images, are grouped into a batch.",
                 "consumes": [
                     "multipart/form-data"
                 ],
                 "produces": [
-                    "text/plain"
+                    "application/json"
                 ],
                 "summary": "Upload a File",
                 "parameters": [
                     {
                         "type": "file",
-                        "description": "File to upload",
+                        "description": "File or archive to upload, may be repeated",
                         "name": "file",
                         "in": "formData",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Detection model, see /api/v1/models",
+                        "name": "model",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Minimum confidence (0-1), defaults to the model's",
+                        "name": "conf",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "number",
+                        "description": "NMS IoU threshold (0-1), defaults to the model's",
+                        "name": "iou",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Inference size in pixels, a multiple of 32",
+                        "name": "img_size",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 0,
+                        "description": "Queue priority from -10 to 10, positive values are reserved for admins",
+                        "name": "priority",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Batch created for several files or an archive",
+                        "schema": {
+                            "$ref": "#/definitions/main.batchResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/batches/{id}": {
+            "get": {
+                "description": "Returns the detection progress of a batch upload and the detections found so far, aggregated by class.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get Batch",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Batch ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.batchResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Batch not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/files/{id}/detections": {
+            "get": {
+                "description": "Returns the objects detected in an uploaded file.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "List Detections",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Upload ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/db.Detection"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "File not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/jobs": {
+            "get": {
+                "description": "Returns the detection jobs of the caller, newest first. Admins see every job.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "List Jobs",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/db.Job"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/jobs/{id}": {
+            "get": {
+                "description": "Returns the state of a single detection job.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get Job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "File uploaded successfully",
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/db.Job"
+                        }
+                    },
+                    "404": {
+                        "description": "Job not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Cancels a detection job by deleting its pod.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Cancel Job",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/db.Job"
+                        }
+                    },
+                    "404": {
+                        "description": "Job not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Job already finished",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/jobs/{id}/logs": {
+            "get": {
+                "description": "Streams the log of the detector pod of a job. Finished pods are served from the log archive. WebSocket requests receive one text message per log line.",
+                "produces": [
+                    "text/plain"
+                ],
+                "summary": "Job Logs",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Job ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Keep streaming until the pod exits",
+                        "name": "follow",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The log",
                         "schema": {
                             "type": "string"
                         }
@@ -47,6 +313,52 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "404": {
+                        "description": "Job or log not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/models": {
+            "get": {
+                "description": "Returns the detection models an upload can choose from, with their default settings.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "List Models",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Registry"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/queue": {
+            "get": {
+                "description": "Returns how many detection jobs wait in the queue and how many run, overall and for the caller.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Queue Status",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/db.QueueStats"
+                        }
+                    },
                     "500": {
                         "description": "Internal server error",
                         "schema": {
@@ -56,9 +368,85 @@ const docTemplate = `{
                 }
             }
         },
-        "/files/{filename}": {
+        "/api/v1/search": {
             "get": {
-                "description": "Serves the actual file content.",
+                "description": "Finds uploads by detected object class and confidence.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Search Uploads",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Object class, e.g. person",
+                        "name": "class",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Minimum detection confidence (0-1)",
+                        "name": "min_conf",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 1,
+                        "description": "Minimum number of matching detections in one image or video frame",
+                        "name": "min_count",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Uploaded at or after (RFC3339 or YYYY-MM-DD)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Uploaded before (RFC3339, or YYYY-MM-DD for the end of that day)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 50,
+                        "description": "Page size",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 0,
+                        "description": "Page offset",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.searchResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/files/{id}": {
+            "get": {
+                "description": "Serves an uploaded file, or one of its detection outputs when a sub-path is given.",
                 "produces": [
                     "application/octet-stream"
                 ],
@@ -66,8 +454,8 @@ const docTemplate = `{
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "The name of the file",
-                        "name": "filename",
+                        "description": "Upload ID",
+                        "name": "id",
                         "in": "path",
                         "required": true
                     }
@@ -90,7 +478,7 @@ const docTemplate = `{
         },
         "/lists": {
             "get": {
-                "description": "Returns a list of uploaded files.",
+                "description": "Returns a list of the caller's uploaded files. Admins see every file.",
                 "produces": [
                     "text/html"
                 ],
@@ -111,27 +499,27 @@ const docTemplate = `{
                 }
             }
         },
-        "/lists/{filename}": {
+        "/lists/{id}": {
             "get": {
-                "description": "Serves the requested image file.",
+                "description": "Shows an uploaded file together with its detection results.",
                 "produces": [
-                    "application/octet-stream"
+                    "text/html"
                 ],
                 "summary": "Display Image",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "The name of the file to display",
-                        "name": "filename",
+                        "description": "Upload ID",
+                        "name": "id",
                         "in": "path",
                         "required": true
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "The requested file",
+                        "description": "The file page",
                         "schema": {
-                            "type": "file"
+                            "type": "string"
                         }
                     },
                     "404": {
@@ -149,6 +537,351 @@ const docTemplate = `{
                 }
             }
         }
+    },
+    "definitions": {
+        "db.Batch": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "owner": {
+                    "type": "string"
+                }
+            }
+        },
+        "db.BatchProgress": {
+            "type": "object",
+            "properties": {
+                "done": {
+                    "type": "integer"
+                },
+                "states": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "db.ClassSummary": {
+            "type": "object",
+            "properties": {
+                "class_name": {
+                    "type": "string"
+                },
+                "detections": {
+                    "type": "integer"
+                },
+                "files": {
+                    "type": "integer"
+                },
+                "max_confidence": {
+                    "type": "number"
+                }
+            }
+        },
+        "db.Detection": {
+            "type": "object",
+            "properties": {
+                "class_id": {
+                    "type": "integer"
+                },
+                "class_name": {
+                    "type": "string"
+                },
+                "confidence": {
+                    "type": "number"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "frame": {
+                    "type": "integer"
+                },
+                "height": {
+                    "type": "number"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "upload_id": {
+                    "type": "string"
+                },
+                "width": {
+                    "type": "number"
+                },
+                "x_center": {
+                    "type": "number"
+                },
+                "y_center": {
+                    "type": "number"
+                }
+            }
+        },
+        "db.Job": {
+            "type": "object",
+            "properties": {
+                "attempts": {
+                    "description": "Attempts lists the failed runs of the job, oldest first.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/db.JobAttempt"
+                    }
+                },
+                "cluster": {
+                    "description": "Cluster names the cluster the job was dispatched to.",
+                    "type": "string"
+                },
+                "conf": {
+                    "type": "number"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "exit_code": {
+                    "type": "integer"
+                },
+                "filename": {
+                    "type": "string"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "img_size": {
+                    "type": "integer"
+                },
+                "iou": {
+                    "type": "number"
+                },
+                "job_name": {
+                    "type": "string"
+                },
+                "model": {
+                    "type": "string"
+                },
+                "owner": {
+                    "type": "string"
+                },
+                "pod_name": {
+                    "type": "string"
+                },
+                "priority": {
+                    "type": "integer"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "retry_at": {
+                    "description": "RetryAt is when a job queued for another attempt may run again.",
+                    "type": "string"
+                },
+                "reused_from": {
+                    "type": "string"
+                },
+                "started_at": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "upload_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "db.JobAttempt": {
+            "type": "object",
+            "properties": {
+                "cluster": {
+                    "type": "string"
+                },
+                "exit_code": {
+                    "type": "integer"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "job_name": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "pod_name": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "started_at": {
+                    "type": "string"
+                },
+                "transient": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "db.QueueStats": {
+            "type": "object",
+            "properties": {
+                "in_flight": {
+                    "type": "integer"
+                },
+                "queued": {
+                    "type": "integer"
+                },
+                "user_in_flight": {
+                    "type": "integer"
+                },
+                "user_queued": {
+                    "type": "integer"
+                }
+            }
+        },
+        "db.SearchResult": {
+            "type": "object",
+            "properties": {
+                "filename": {
+                    "type": "string"
+                },
+                "matches": {
+                    "type": "integer"
+                },
+                "max_confidence": {
+                    "type": "number"
+                },
+                "upload_id": {
+                    "type": "string"
+                },
+                "uploaded_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.batchResponse": {
+            "type": "object",
+            "properties": {
+                "batch": {
+                    "$ref": "#/definitions/db.Batch"
+                },
+                "jobs": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/db.Job"
+                    }
+                },
+                "progress": {
+                    "$ref": "#/definitions/db.BatchProgress"
+                },
+                "summary": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/db.ClassSummary"
+                    }
+                }
+            }
+        },
+        "main.searchResponse": {
+            "type": "object",
+            "properties": {
+                "limit": {
+                    "type": "integer"
+                },
+                "offset": {
+                    "type": "integer"
+                },
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/db.SearchResult"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.Model": {
+            "type": "object",
+            "properties": {
+                "cluster_selector": {
+                    "description": "ClusterSelector limits the clusters the model runs in to those with\nthese labels, e.g. clusters with GPUs.",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "defaults": {
+                    "$ref": "#/definitions/models.Settings"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "image": {
+                    "type": "string"
+                },
+                "input_types": {
+                    "description": "InputTypes lists the accepted file extensions. Empty accepts any file.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "max_img_size": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "weights": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.Registry": {
+            "type": "object",
+            "properties": {
+                "default": {
+                    "type": "string"
+                },
+                "models": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Model"
+                    }
+                }
+            }
+        },
+        "models.Settings": {
+            "type": "object",
+            "properties": {
+                "conf": {
+                    "type": "number"
+                },
+                "img_size": {
+                    "type": "integer"
+                },
+                "iou": {
+                    "type": "number"
+                }
+            }
+        }
     }
 }`
 
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	auth "helloworld/db"
+	"helloworld/events"
+	"helloworld/kafka"
+	"helloworld/kubeapi"
+)
+
+// kafkaCheckTimeout bounds the connectivity check at startup.
+const kafkaCheckTimeout = 30 * time.Second
+
+// hostname names this replica as the source of the events it publishes.
+var hostname, _ = os.Hostname()
+
+// newEventBus connects to Kafka when KAFKA_ENABLED is set, see
+// kafkaConfigFromEnv, and checks that a broker can be reached. Every replica
+// consumes in a group of its own, named after KAFKA_REPLICA_ID, so each
+// receives every event. A group commits what it consumed, so a replica that
+// restarts under the same ID continues where it stopped. The ID defaults to
+// the hostname, which changes with every pod of a Deployment: such a replica
+// starts in a new group with the latest events and misses those published
+// while it was down, so delivery across restarts is at most once. A
+// StatefulSet gives stable pod names to use instead. Without Kafka the
+// server only notifies its own WebSocket clients.
+func newEventBus(ctx context.Context) (*kafka.MyKafka, error) {
+	enabled, err := strconv.ParseBool(getenv("KAFKA_ENABLED", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid KAFKA_ENABLED: %w", err)
+	}
+	if !enabled {
+		return nil, nil
+	}
+	cfg, err := kafkaConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	cfg.GroupID += "-" + getenv("KAFKA_REPLICA_ID", hostname)
+
+	bus, err := kafka.NewMyKafka(cfg)
+	if err != nil {
+		return nil, err
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, kafkaCheckTimeout)
+	defer cancel()
+	if err := bus.Check(checkCtx); err != nil {
+		return nil, err
+	}
+	// The writer is shared by concurrent publishers, so it is created up
+	// front rather than by the first of them.
+	if err := bus.InitWriter(); err != nil {
+		return nil, err
+	}
+	return bus, nil
+}
+
+// kafkaConfigFromEnv reads the Kafka configuration from the YAML file
+// KAFKA_CONFIG_FILE, if set, and lets the KAFKA_* variables override it.
+// KAFKA_BROKERS is a comma-separated list and KAFKA_TOPICS maps event types
+// to topics as in "upload.created=uploads,detection.completed=results".
+func kafkaConfigFromEnv() (kafka.Config, error) {
+	var cfg kafka.Config
+	if filename := os.Getenv("KAFKA_CONFIG_FILE"); filename != "" {
+		var err error
+		if cfg, err = kafka.LoadConfig(filename); err != nil {
+			return cfg, err
+		}
+	}
+
+	for key, field := range map[string]*string{
+		"KAFKA_CLIENT_ID":      &cfg.ClientID,
+		"KAFKA_GROUP_ID":       &cfg.GroupID,
+		"KAFKA_TOPIC":          &cfg.DefaultTopic,
+		"KAFKA_SASL_MECHANISM": &cfg.SASL.Mechanism,
+		"KAFKA_SASL_USERNAME":  &cfg.SASL.Username,
+		"KAFKA_SASL_PASSWORD":  &cfg.SASL.Password,
+		"KAFKA_TLS_CA_FILE":    &cfg.TLS.CAFile,
+		"KAFKA_TLS_CERT_FILE":  &cfg.TLS.CertFile,
+		"KAFKA_TLS_KEY_FILE":   &cfg.TLS.KeyFile,
+		"KAFKA_COMPRESSION":    &cfg.Compression,
+		"KAFKA_ACKS":           &cfg.Acks,
+		"KAFKA_DLQ_TOPIC":      &cfg.DeadLetterTopic,
+	} {
+		if v := os.Getenv(key); v != "" {
+			*field = v
+		}
+	}
+	for key, field := range map[string]*bool{
+		"KAFKA_TLS":          &cfg.TLS.Enabled,
+		"KAFKA_TLS_INSECURE": &cfg.TLS.InsecureSkipVerify,
+	} {
+		if v := os.Getenv(key); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid %s %q", key, v)
+			}
+			*field = b
+		}
+	}
+	for key, field := range map[string]*int{
+		"KAFKA_BATCH_SIZE":   &cfg.BatchSize,
+		"KAFKA_MAX_ATTEMPTS": &cfg.MaxAttempts,
+	} {
+		if v := os.Getenv(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid %s %q", key, v)
+			}
+			*field = n
+		}
+	}
+	for key, field := range map[string]*time.Duration{
+		"KAFKA_RETRY_BACKOFF":     &cfg.RetryBackoff,
+		"KAFKA_MAX_RETRY_BACKOFF": &cfg.MaxRetryBackoff,
+	} {
+		if v := os.Getenv(key); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid %s %q", key, v)
+			}
+			*field = d
+		}
+	}
+	if v := os.Getenv("KAFKA_BROKERS"); v != "" {
+		cfg.Brokers = strings.Split(v, ",")
+	}
+	if v := os.Getenv("KAFKA_TOPICS"); v != "" {
+		cfg.Topics = map[string]string{}
+		for _, pair := range strings.Split(v, ",") {
+			eventType, topic, ok := strings.Cut(pair, "=")
+			if !ok || eventType == "" || topic == "" {
+				return cfg, fmt.Errorf("invalid KAFKA_TOPICS entry %q", pair)
+			}
+			cfg.Topics[eventType] = topic
+		}
+	}
+	return cfg, nil
+}
+
+// outbox encodes evs for the outbox table. Recorded in the transaction of
+// the change they announce, they are published to every replica by the
+// relay once it commits, see announce. Without Kafka nothing is recorded.
+func (a *App) outbox(evs ...events.Event) []auth.OutboxMessage {
+	if a.Events == nil {
+		return nil
+	}
+	var messages []auth.OutboxMessage
+	for _, event := range evs {
+		env, err := events.New("/detector/"+hostname, event)
+		if err != nil {
+			log.Printf("Failed to create %s event: %v", event.Type(), err)
+			continue
+		}
+		payload, err := json.Marshal(env)
+		if err != nil {
+			log.Printf("Failed to encode %s event %s: %v", env.Type, env.ID, err)
+			continue
+		}
+		messages = append(messages, auth.OutboxMessage{EventType: env.Type, Key: env.Subject, Payload: payload})
+	}
+	return messages
+}
+
+// announce is called with the events of a change once it committed. With
+// Kafka it wakes the relay publishing their outbox messages, without it
+// delivers them to the local WebSocket clients only.
+func (a *App) announce(evs ...events.Event) {
+	if a.Events != nil {
+		a.Events.NotifyOutbox()
+		return
+	}
+	for _, event := range evs {
+		a.deliverEvent(event)
+	}
+}
+
+// outboxStore relays the outbox table to Kafka, see kafka.MyKafka.RelayOutbox.
+type outboxStore struct{}
+
+func (outboxStore) RelayPending(limit int, send func([]kafka.OutboxMessage) error) (int, error) {
+	return auth.RelayOutbox(limit, func(messages []auth.OutboxMessage) error {
+		batch := make([]kafka.OutboxMessage, len(messages))
+		for i, m := range messages {
+			batch[i] = kafka.OutboxMessage{EventType: m.EventType, Key: []byte(m.Key), Value: m.Payload}
+		}
+		return send(batch)
+	})
+}
+
+// jobStateEvent announces that job entered state.
+func jobStateEvent(job *auth.Job, state kubeapi.JobState, reason, message string) *events.JobStateChanged {
+	return &events.JobStateChanged{
+		JobID:    job.ID,
+		UploadID: job.UploadID,
+		Owner:    job.Owner,
+		Filename: job.Filename,
+		State:    string(state),
+		Reason:   reason,
+		Message:  message,
+	}
+}
+
+// detectionFinishedEvents announce that job ended in state. message, if
+// any, explains the outcome to the owner of the job.
+func detectionFinishedEvents(job *auth.Job, state kubeapi.JobState, reason, message string) []events.Event {
+	return []events.Event{
+		jobStateEvent(job, state, reason, message),
+		&events.DetectionCompleted{
+			JobID:      job.ID,
+			UploadID:   job.UploadID,
+			Owner:      job.Owner,
+			Filename:   job.Filename,
+			State:      string(state),
+			Reason:     reason,
+			Message:    message,
+			ReusedFrom: job.ReusedFrom,
+		},
+	}
+}
+
+// deliverEvent shows event to the WebSocket clients of this replica: job
+// events to the owner of the job, uploads to everyone.
+func (a *App) deliverEvent(event events.Event) {
+	switch e := event.(type) {
+	case *events.UploadCreated:
+		a.UploadNotificationChan <- fmt.Sprintf("File '%s' uploaded, by an other user", e.Filename)
+	case *events.JobStateChanged:
+		// Finished jobs are announced by their DetectionCompleted.
+		if !kubeapi.JobState(e.State).Terminal() {
+			a.notifyUser(e.Owner, fmt.Sprintf("Detection of '%s' is %s", e.Filename, e.State))
+		}
+	case *events.DetectionCompleted:
+		notification := fmt.Sprintf("Detection of '%s' finished: %s", e.Filename, e.State)
+		if e.Message != "" {
+			notification += " (" + e.Message + ")"
+		}
+		a.notifyUser(e.Owner, notification)
+	case *events.UserRegistered:
+		log.Printf("User %s registered", e.Username)
+	}
+}
@@ -0,0 +1,276 @@
+// Package events defines the events the servers exchange through Kafka and
+// the CloudEvents 1.0 envelope they travel in. Producers and consumers share
+// these types, so changing an event means changing its schema version here.
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrUnknownType and ErrUnsupportedVersion mark events this build cannot
+	// interpret, e.g. ones published by a newer release. Retrying them does
+	// not help, so consumers skip them.
+	ErrUnknownType        = errors.New("unknown event type")
+	ErrUnsupportedVersion = errors.New("unsupported event version")
+	ErrInvalidEvent       = errors.New("invalid event")
+)
+
+const (
+	// SpecVersion is the CloudEvents version of the envelope.
+	SpecVersion = "1.0"
+	// ContentType is the media type of encoded envelopes, which use the
+	// structured content mode of CloudEvents.
+	ContentType = "application/cloudevents+json"
+
+	dataContentType = "application/json"
+)
+
+// Event types.
+const (
+	TypeUploadCreated      = "upload.created"
+	TypeJobStateChanged    = "job.state_changed"
+	TypeDetectionCompleted = "detection.completed"
+	TypeUserRegistered     = "user.registered"
+)
+
+// Event is the data of an envelope.
+type Event interface {
+	// Type returns one of the event types.
+	Type() string
+	// Subject names what the event is about. Events of the same subject are
+	// published to the same partition, so they are consumed in order.
+	Subject() string
+	validate() error
+}
+
+// version is a schema version of the form "major.minor". Minor versions
+// only add optional fields, so a consumer can read any minor version of the
+// major version it knows.
+type version struct {
+	major, minor int
+}
+
+func (v version) String() string {
+	return fmt.Sprintf("%d.%d", v.major, v.minor)
+}
+
+func parseVersion(s string) (version, error) {
+	major, minor, ok := strings.Cut(s, ".")
+	if !ok {
+		return version{}, fmt.Errorf("schema version %q is not major.minor", s)
+	}
+	var v version
+	var err error
+	if v.major, err = strconv.Atoi(major); err != nil {
+		return version{}, fmt.Errorf("schema version %q is not major.minor", s)
+	}
+	if v.minor, err = strconv.Atoi(minor); err != nil {
+		return version{}, fmt.Errorf("schema version %q is not major.minor", s)
+	}
+	return v, nil
+}
+
+type schema struct {
+	version version
+	new     func() Event
+}
+
+// schemas lists the current schema version of every event type.
+var schemas = map[string]schema{
+	TypeUploadCreated:      {version{1, 0}, func() Event { return new(UploadCreated) }},
+	TypeJobStateChanged:    {version{1, 0}, func() Event { return new(JobStateChanged) }},
+	TypeDetectionCompleted: {version{1, 0}, func() Event { return new(DetectionCompleted) }},
+	TypeUserRegistered:     {version{1, 0}, func() Event { return new(UserRegistered) }},
+}
+
+// UploadCreated announces a new upload.
+type UploadCreated struct {
+	UploadID string `json:"upload_id"`
+	Owner    string `json:"owner"`
+	Filename string `json:"filename"`
+	BatchID  string `json:"batch_id,omitempty"`
+}
+
+func (e *UploadCreated) Type() string    { return TypeUploadCreated }
+func (e *UploadCreated) Subject() string { return e.UploadID }
+
+func (e *UploadCreated) validate() error {
+	return required("upload_id", e.UploadID, "owner", e.Owner, "filename", e.Filename)
+}
+
+// JobStateChanged announces that the detection job of an upload entered
+// State. Jobs that finish are announced by a DetectionCompleted as well.
+type JobStateChanged struct {
+	JobID    string `json:"job_id"`
+	UploadID string `json:"upload_id"`
+	Owner    string `json:"owner"`
+	Filename string `json:"filename"`
+	State    string `json:"state"`
+	Reason   string `json:"reason,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+func (e *JobStateChanged) Type() string    { return TypeJobStateChanged }
+func (e *JobStateChanged) Subject() string { return e.UploadID }
+
+func (e *JobStateChanged) validate() error {
+	return required("job_id", e.JobID, "upload_id", e.UploadID, "owner", e.Owner, "state", e.State)
+}
+
+// DetectionCompleted announces that the detection of an upload finished in
+// State, successfully or not.
+type DetectionCompleted struct {
+	JobID    string `json:"job_id"`
+	UploadID string `json:"upload_id"`
+	Owner    string `json:"owner"`
+	Filename string `json:"filename"`
+	State    string `json:"state"`
+	Reason   string `json:"reason,omitempty"`
+	Message  string `json:"message,omitempty"`
+	// ReusedFrom is the upload whose detections were reused, if any.
+	ReusedFrom string `json:"reused_from,omitempty"`
+}
+
+func (e *DetectionCompleted) Type() string    { return TypeDetectionCompleted }
+func (e *DetectionCompleted) Subject() string { return e.UploadID }
+
+func (e *DetectionCompleted) validate() error {
+	return required("job_id", e.JobID, "upload_id", e.UploadID, "owner", e.Owner, "state", e.State)
+}
+
+// UserRegistered announces a new user account.
+type UserRegistered struct {
+	Username string `json:"username"`
+}
+
+func (e *UserRegistered) Type() string    { return TypeUserRegistered }
+func (e *UserRegistered) Subject() string { return e.Username }
+
+func (e *UserRegistered) validate() error {
+	return required("username", e.Username)
+}
+
+// required checks that the values of the name, value pairs are not empty.
+func required(pairs ...string) error {
+	var missing []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			missing = append(missing, pairs[i])
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Envelope is a CloudEvents 1.0 event in structured JSON form.
+type Envelope struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject,omitempty"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	// SchemaVersion is an extension attribute holding the version of the
+	// schema of Data.
+	SchemaVersion string          `json:"schemaversion"`
+	Data          json.RawMessage `json:"data"`
+}
+
+// New wraps event in an envelope with a new ID. source identifies the
+// publisher, e.g. "/detector/<hostname>".
+func New(source string, event Event) (*Envelope, error) {
+	s, ok := schemas[event.Type()]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownType, event.Type())
+	}
+	if err := event.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidEvent, event.Type(), err)
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{
+		SpecVersion:     SpecVersion,
+		ID:              uuid.NewString(),
+		Source:          source,
+		Type:            event.Type(),
+		Subject:         event.Subject(),
+		Time:            time.Now().UTC(),
+		DataContentType: dataContentType,
+		SchemaVersion:   s.version.String(),
+		Data:            data,
+	}, nil
+}
+
+// Decode parses an encoded envelope and its data. Attributes and fields this
+// build does not know are rejected, except for the fields a newer minor
+// version of a schema adds. Envelopes of unknown types or major versions
+// are returned along with ErrUnknownType or ErrUnsupportedVersion.
+func Decode(data []byte) (*Envelope, Event, error) {
+	var env Envelope
+	if err := decodeStrict(data, &env); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidEvent, err)
+	}
+	if env.SpecVersion != SpecVersion {
+		return &env, nil, fmt.Errorf("%w: specversion %q", ErrUnsupportedVersion, env.SpecVersion)
+	}
+	if err := required("id", env.ID, "source", env.Source, "type", env.Type); err != nil {
+		return &env, nil, fmt.Errorf("%w: %v", ErrInvalidEvent, err)
+	}
+	if env.DataContentType != dataContentType {
+		return &env, nil, fmt.Errorf("%w: datacontenttype %q", ErrInvalidEvent, env.DataContentType)
+	}
+	s, ok := schemas[env.Type]
+	if !ok {
+		return &env, nil, fmt.Errorf("%w %q", ErrUnknownType, env.Type)
+	}
+	v, err := parseVersion(env.SchemaVersion)
+	if err != nil {
+		return &env, nil, fmt.Errorf("%w: %v", ErrInvalidEvent, err)
+	}
+	if v.major != s.version.major {
+		return &env, nil, fmt.Errorf("%w: %s %s, supported %d.x", ErrUnsupportedVersion, env.Type, v, s.version.major)
+	}
+
+	event := s.new()
+	if v.minor > s.version.minor {
+		err = json.Unmarshal(env.Data, event)
+	} else {
+		err = decodeStrict(env.Data, event)
+	}
+	if err == nil {
+		err = event.validate()
+	}
+	if err != nil {
+		return &env, nil, fmt.Errorf("%w: %s %s: %v", ErrInvalidEvent, env.Type, env.ID, err)
+	}
+	return &env, event, nil
+}
+
+// decodeStrict decodes the single JSON value in data into v, rejecting
+// unknown fields.
+func decodeStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("trailing data after JSON value")
+	}
+	return nil
+}
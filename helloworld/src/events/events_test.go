@@ -0,0 +1,114 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func encode(t *testing.T, env *Envelope) []byte {
+	t.Helper()
+	data, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRoundTrip(t *testing.T) {
+	sent := &JobStateChanged{JobID: "j", UploadID: "u", Owner: "alice", Filename: "cat.jpg", State: "Running"}
+	env, err := New("/detector/test", sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Subject != "u" || env.SchemaVersion != "1.0" || env.SpecVersion != SpecVersion {
+		t.Errorf("envelope = %+v", env)
+	}
+
+	decoded, event, err := Decode(encode(t, env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ID != env.ID {
+		t.Errorf("ID = %q, want %q", decoded.ID, env.ID)
+	}
+	if got, ok := event.(*JobStateChanged); !ok || *got != *sent {
+		t.Errorf("event = %#v, want %#v", event, sent)
+	}
+}
+
+func TestNewRejectsInvalidEvents(t *testing.T) {
+	if _, err := New("/detector/test", &UploadCreated{UploadID: "u"}); !errors.Is(err, ErrInvalidEvent) {
+		t.Errorf("New = %v, want ErrInvalidEvent", err)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	valid, err := New("/detector/test", &UserRegistered{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		modify func(m map[string]any)
+		want   error
+	}{
+		{"unknown type", func(m map[string]any) { m["type"] = "user.deleted" }, ErrUnknownType},
+		{"newer major version", func(m map[string]any) { m["schemaversion"] = "2.0" }, ErrUnsupportedVersion},
+		{"other specversion", func(m map[string]any) { m["specversion"] = "0.3" }, ErrUnsupportedVersion},
+		{"malformed version", func(m map[string]any) { m["schemaversion"] = "1" }, ErrInvalidEvent},
+		{"unknown attribute", func(m map[string]any) { m["extra"] = true }, ErrInvalidEvent},
+		{"unknown field", func(m map[string]any) {
+			m["data"] = map[string]any{"username": "alice", "email": "a@example.com"}
+		}, ErrInvalidEvent},
+		{"missing field", func(m map[string]any) { m["data"] = map[string]any{} }, ErrInvalidEvent},
+		{"missing id", func(m map[string]any) { delete(m, "id") }, ErrInvalidEvent},
+		{"other content type", func(m map[string]any) { m["datacontenttype"] = "text/plain" }, ErrInvalidEvent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m map[string]any
+			if err := json.Unmarshal(encode(t, valid), &m); err != nil {
+				t.Fatal(err)
+			}
+			tt.modify(m)
+			data, _ := json.Marshal(m)
+			if _, _, err := Decode(data); !errors.Is(err, tt.want) {
+				t.Errorf("Decode = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestDecodeNewerMinorVersion checks that fields added by a newer minor
+// version of a schema are ignored.
+func TestDecodeNewerMinorVersion(t *testing.T) {
+	env, err := New("/detector/test", &UserRegistered{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.SchemaVersion = "1.3"
+	env.Data = json.RawMessage(`{"username":"alice","email":"a@example.com"}`)
+	_, event, err := Decode(encode(t, env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := event.(*UserRegistered); e.Username != "alice" {
+		t.Errorf("event = %+v", e)
+	}
+}
+
+func TestDecodeTrailingData(t *testing.T) {
+	env, err := New("/detector/test", &UserRegistered{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := string(encode(t, env)) + `{}`
+	if _, _, err := Decode([]byte(data)); !errors.Is(err, ErrInvalidEvent) {
+		t.Errorf("Decode = %v, want ErrInvalidEvent", err)
+	}
+	if _, _, err := Decode([]byte(strings.Repeat("x", 3))); !errors.Is(err, ErrInvalidEvent) {
+		t.Errorf("Decode of garbage = %v, want ErrInvalidEvent", err)
+	}
+}
@@ -0,0 +1,222 @@
+// Package extract unpacks uploaded .zip and .tar.gz archives while guarding
+// against path traversal (zip-slip) and decompression bombs.
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+var (
+	ErrUnsafePath     = errors.New("archive entry has an unsafe path")
+	ErrTooManyEntries = errors.New("archive has too many entries")
+	ErrTooLarge       = errors.New("archive expands beyond the allowed size")
+	// ErrInvalidArchive is returned for archives that are truncated, corrupt
+	// or not in the format their name suggests.
+	ErrInvalidArchive = errors.New("invalid archive")
+)
+
+// Limits bound what a single archive may expand to.
+type Limits struct {
+	MaxEntries    int
+	MaxEntrySize  int64
+	MaxTotalSize  int64
+	MaxZipRatio   int64
+	AllowedSuffix []string
+}
+
+// Entry is one file extracted from an archive.
+type Entry struct {
+	Name string
+	Size int64
+	Body io.Reader
+}
+
+// IsArchive reports whether filename names an archive Walk can unpack.
+func IsArchive(filename string) bool {
+	name := strings.ToLower(filename)
+	return strings.HasSuffix(name, ".zip") || strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+// Walk calls fn for every regular file of the archive named filename whose
+// suffix is allowed. Directories, hidden files and other entries are
+// skipped. The Body of an entry is only valid during the call.
+func Walk(filename string, r io.ReaderAt, size int64, limits Limits, fn func(Entry) error) error {
+	w := &walker{limits: limits, fn: fn}
+	if strings.HasSuffix(strings.ToLower(filename), ".zip") {
+		return w.zip(r, size)
+	}
+	return w.tarGz(io.NewSectionReader(r, 0, size))
+}
+
+type walker struct {
+	limits  Limits
+	fn      func(Entry) error
+	entries int
+	total   int64
+}
+
+func (w *walker) zip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return invalid(err)
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		name, ok, err := w.accept(f.Name, int64(f.UncompressedSize64))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if w.limits.MaxZipRatio > 0 && f.CompressedSize64 > 0 &&
+			f.UncompressedSize64/f.CompressedSize64 > uint64(w.limits.MaxZipRatio) {
+			return fmt.Errorf("%w: %s has a suspicious compression ratio", ErrTooLarge, f.Name)
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return invalid(err)
+		}
+		err = w.emit(name, int64(f.UncompressedSize64), rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *walker) tarGz(r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return invalid(err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return invalid(err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name, ok, err := w.accept(hdr.Name, hdr.Size)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := w.emit(name, hdr.Size, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// accept validates an entry before it is read and reports whether it should
+// be extracted.
+func (w *walker) accept(name string, declaredSize int64) (string, bool, error) {
+	if !safePath(name) {
+		return "", false, fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+	base := path.Base(name)
+	if strings.HasPrefix(base, ".") || !w.allowed(base) {
+		return "", false, nil
+	}
+
+	w.entries++
+	if w.limits.MaxEntries > 0 && w.entries > w.limits.MaxEntries {
+		return "", false, ErrTooManyEntries
+	}
+	if w.limits.MaxEntrySize > 0 && declaredSize > w.limits.MaxEntrySize {
+		return "", false, fmt.Errorf("%w: %s", ErrTooLarge, name)
+	}
+	return name, true, nil
+}
+
+// emit hands the entry to fn, counting the bytes actually decompressed so
+// that forged size headers cannot bypass the limits.
+func (w *walker) emit(name string, size int64, r io.Reader) error {
+	body := &limitReader{r: r, entryLeft: w.limits.MaxEntrySize, walker: w}
+	if err := w.fn(Entry{Name: name, Size: size, Body: body}); err != nil {
+		return err
+	}
+	return body.err
+}
+
+func (w *walker) allowed(name string) bool {
+	if len(w.limits.AllowedSuffix) == 0 {
+		return true
+	}
+	lower := strings.ToLower(name)
+	for _, suffix := range w.limits.AllowedSuffix {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// invalid wraps an error of the archive readers in ErrInvalidArchive.
+func invalid(err error) error {
+	return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+}
+
+func safePath(name string) bool {
+	if name == "" || strings.Contains(name, "\\") || strings.HasPrefix(name, "/") {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+type limitReader struct {
+	r         io.Reader
+	entryLeft int64
+	walker    *walker
+	err       error
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	n, err := l.r.Read(p)
+	l.walker.total += int64(n)
+	if l.walker.limits.MaxEntrySize > 0 {
+		l.entryLeft -= int64(n)
+		if l.entryLeft < 0 {
+			l.err = ErrTooLarge
+		}
+	}
+	if l.walker.limits.MaxTotalSize > 0 && l.walker.total > l.walker.limits.MaxTotalSize {
+		l.err = ErrTooLarge
+	}
+	if err != nil && err != io.EOF {
+		// Corrupt compressed data or a truncated entry.
+		l.err = invalid(err)
+	}
+	if l.err != nil {
+		return n, l.err
+	}
+	return n, err
+}
@@ -0,0 +1,191 @@
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type file struct {
+	name string
+	body []byte
+}
+
+func zipArchive(t *testing.T, files ...file) *bytes.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(f.body)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func tarGzArchive(t *testing.T, files ...file) *bytes.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(f.body)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+// walk returns the names of the entries Walk extracts, reading them fully.
+func walk(filename string, r *bytes.Reader, limits Limits) ([]string, error) {
+	var names []string
+	err := Walk(filename, r, r.Size(), limits, func(e Entry) error {
+		if _, err := io.Copy(io.Discard, e.Body); err != nil {
+			return err
+		}
+		names = append(names, e.Name)
+		return nil
+	})
+	return names, err
+}
+
+func TestWalk(t *testing.T) {
+	files := []file{
+		{"a.jpg", []byte("a")},
+		{"dir/b.PNG", []byte("b")},
+		{"notes.txt", []byte("skipped")},
+		{"dir/.hidden.jpg", []byte("skipped")},
+	}
+	limits := Limits{AllowedSuffix: []string{".jpg", ".png"}}
+	want := []string{"a.jpg", "dir/b.PNG"}
+
+	for name, archive := range map[string]*bytes.Reader{
+		"images.zip":    zipArchive(t, files...),
+		"images.tar.gz": tarGzArchive(t, files...),
+	} {
+		got, err := walk(name, archive, limits)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: extracted %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestWalkUnsafePath(t *testing.T) {
+	for _, name := range []string{"../evil.jpg", "a/../../evil.jpg", "/etc/evil.jpg", `..\evil.jpg`} {
+		for filename, archive := range map[string]*bytes.Reader{
+			"x.zip":    zipArchive(t, file{name, []byte("x")}),
+			"x.tar.gz": tarGzArchive(t, file{name, []byte("x")}),
+		} {
+			if _, err := walk(filename, archive, Limits{}); !errors.Is(err, ErrUnsafePath) {
+				t.Errorf("%s with %q: got %v, want ErrUnsafePath", filename, name, err)
+			}
+		}
+	}
+}
+
+func TestWalkLimits(t *testing.T) {
+	big := bytes.Repeat([]byte{0}, 10000)
+	tests := []struct {
+		name   string
+		limits Limits
+		files  []file
+		want   error
+	}{
+		{"entries", Limits{MaxEntries: 1}, []file{{"a.jpg", nil}, {"b.jpg", nil}}, ErrTooManyEntries},
+		{"entry size", Limits{MaxEntrySize: 100}, []file{{"a.jpg", big}}, ErrTooLarge},
+		{"total size", Limits{MaxTotalSize: 15000}, []file{{"a.jpg", big}, {"b.jpg", big}}, ErrTooLarge},
+	}
+	for _, tt := range tests {
+		for filename, archive := range map[string]*bytes.Reader{
+			"x.zip":    zipArchive(t, tt.files...),
+			"x.tar.gz": tarGzArchive(t, tt.files...),
+		} {
+			if _, err := walk(filename, archive, tt.limits); !errors.Is(err, tt.want) {
+				t.Errorf("%s, %s: got %v, want %v", tt.name, filename, err, tt.want)
+			}
+		}
+	}
+}
+
+func TestWalkZipRatio(t *testing.T) {
+	archive := zipArchive(t, file{"bomb.jpg", bytes.Repeat([]byte{0}, 1<<20)})
+	if _, err := walk("bomb.zip", archive, Limits{MaxZipRatio: 100}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("got %v, want ErrTooLarge", err)
+	}
+}
+
+// TestWalkForgedSize checks that an entry whose header understates its size
+// is still stopped once it decompresses beyond the limit.
+func TestWalkForgedSize(t *testing.T) {
+	w := &walker{limits: Limits{MaxEntrySize: 100}, fn: func(e Entry) error {
+		_, err := io.Copy(io.Discard, e.Body)
+		return err
+	}}
+	if err := w.emit("a.jpg", 10, bytes.NewReader(make([]byte, 1000))); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("got %v, want ErrTooLarge", err)
+	}
+}
+
+func TestWalkCorrupt(t *testing.T) {
+	body := bytes.Repeat([]byte("detector "), 1000)
+	truncate := func(r *bytes.Reader, n int) *bytes.Reader {
+		data := make([]byte, r.Size())
+		r.ReadAt(data, 0)
+		return bytes.NewReader(data[:n])
+	}
+	corruptZip := func() *bytes.Reader {
+		r := zipArchive(t, file{"a.jpg", body})
+		data := make([]byte, r.Size())
+		r.ReadAt(data, 0)
+		data[60] ^= 0xff // Inside the compressed data of a.jpg
+		return bytes.NewReader(data)
+	}
+	tests := []struct {
+		name     string
+		filename string
+		archive  *bytes.Reader
+	}{
+		{"not a zip", "a.zip", bytes.NewReader([]byte("not an archive"))},
+		{"truncated zip", "a.zip", truncate(zipArchive(t, file{"a.jpg", body}), 40)},
+		{"corrupt zip data", "a.zip", corruptZip()},
+		{"not gzip", "a.tar.gz", bytes.NewReader([]byte("not an archive"))},
+		{"truncated tar.gz", "a.tar.gz", truncate(tarGzArchive(t, file{"a.jpg", body}, file{"b.jpg", body}), 60)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := walk(tt.filename, tt.archive, Limits{}); !errors.Is(err, ErrInvalidArchive) {
+				t.Errorf("got %v, want ErrInvalidArchive", err)
+			}
+		})
+	}
+}
+
+func TestIsArchive(t *testing.T) {
+	for name, want := range map[string]bool{
+		"a.zip": true, "a.TAR.GZ": true, "a.tgz": true, "a.tar": false, "a.jpg": false,
+	} {
+		if got := IsArchive(name); got != want {
+			t.Errorf("IsArchive(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	auth "helloworld/db"
+	"helloworld/kubeapi"
+	"helloworld/localexec"
+	"helloworld/storage"
+)
+
+const (
+	defaultGCInterval  = 10 * time.Minute
+	defaultGCRetention = time.Hour
+
+	// gcGracePeriod protects cluster objects whose job record is still being
+	// written, see dispatch.
+	gcGracePeriod = 5 * time.Minute
+)
+
+// gcMetrics counts what the garbage collector deleted. expvar publishes it at
+// /debug/vars.
+var gcMetrics = expvar.NewMap("gc")
+
+// gcConfig controls the garbage collector.
+type gcConfig struct {
+	Interval time.Duration
+	// Retention is how long finished Jobs and orphaned results are kept.
+	Retention time.Duration
+	// DryRun only logs what would be deleted.
+	DryRun bool
+}
+
+// gcConfigFromEnv reads GC_INTERVAL, GC_RETENTION and GC_DRY_RUN.
+func gcConfigFromEnv() (gcConfig, error) {
+	cfg := gcConfig{Interval: defaultGCInterval, Retention: defaultGCRetention}
+	for key, d := range map[string]*time.Duration{
+		"GC_INTERVAL":  &cfg.Interval,
+		"GC_RETENTION": &cfg.Retention,
+	} {
+		v := os.Getenv(key)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			return cfg, fmt.Errorf("invalid %s %q", key, v)
+		}
+		*d = parsed
+	}
+	if v := os.Getenv("GC_DRY_RUN"); v != "" {
+		dryRun, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid GC_DRY_RUN %q", v)
+		}
+		cfg.DryRun = dryRun
+	}
+	return cfg, nil
+}
+
+// gcReport counts what one collection deleted, or would have deleted in a
+// dry run.
+type gcReport struct {
+	FinishedJobs    int
+	OrphanedJobs    int
+	OrphanedPods    int
+	LostJobs        int
+	RejectedJobs    int
+	RequeuedJobs    int
+	OrphanedResults int
+	SentEvents      int
+	Failures        int
+}
+
+func (r gcReport) String() string {
+	return fmt.Sprintf("%d finished jobs, %d orphaned jobs, %d orphaned pods, %d lost jobs, %d rejected jobs, %d requeued jobs, %d orphaned results, %d sent events, %d failures",
+		r.FinishedJobs, r.OrphanedJobs, r.OrphanedPods, r.LostJobs, r.RejectedJobs, r.RequeuedJobs, r.OrphanedResults, r.SentEvents, r.Failures)
+}
+
+func (r gcReport) publish() {
+	gcMetrics.Add("runs", 1)
+	gcMetrics.Add("finished_jobs", int64(r.FinishedJobs))
+	gcMetrics.Add("orphaned_jobs", int64(r.OrphanedJobs))
+	gcMetrics.Add("orphaned_pods", int64(r.OrphanedPods))
+	gcMetrics.Add("lost_jobs", int64(r.LostJobs))
+	gcMetrics.Add("rejected_jobs", int64(r.RejectedJobs))
+	gcMetrics.Add("requeued_jobs", int64(r.RequeuedJobs))
+	gcMetrics.Add("orphaned_results", int64(r.OrphanedResults))
+	gcMetrics.Add("sent_events", int64(r.SentEvents))
+	gcMetrics.Add("failures", int64(r.Failures))
+}
+
+// runGC collects garbage every cfg.Interval until ctx is done.
+func (a *App) runGC(ctx context.Context, cfg gcConfig) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		report, err := a.collectGarbage(ctx, cfg)
+		if err != nil {
+			log.Printf("Failed to collect garbage: %v", err)
+		} else if cfg.DryRun {
+			log.Printf("[GC] dry run, would delete %s", report)
+		} else {
+			log.Printf("[GC] deleted %s", report)
+			report.publish()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// gcRun is one collection.
+type gcRun struct {
+	cfg    gcConfig
+	now    time.Time
+	report gcReport
+}
+
+// remove calls del and counts the deletion in count, or only logs it in a
+// dry run.
+func (r *gcRun) remove(count *int, what string, del func() error) {
+	if r.cfg.DryRun {
+		log.Printf("[GC] would delete %s", what)
+		*count++
+		return
+	}
+	if err := del(); err != nil {
+		log.Printf("Failed to delete %s: %v", what, err)
+		r.report.Failures++
+		return
+	}
+	*count++
+}
+
+// collectGarbage deletes finished detector Jobs and published outbox events
+// after the retention period, cluster objects no job record knows about and
+// results of uploads that no longer exist, fails job records whose cluster
+// Job disappeared and queues jobs again that were claimed but never started.
+func (a *App) collectGarbage(ctx context.Context, cfg gcConfig) (gcReport, error) {
+	run := &gcRun{cfg: cfg, now: time.Now()}
+
+	// Records are read before the clusters, so every Job they name exists
+	// in the listings unless it is really gone.
+	started, err := auth.ListStartedJobs()
+	if err != nil {
+		return run.report, fmt.Errorf("listing started jobs: %w", err)
+	}
+	for _, cluster := range a.Clusters.Clusters() {
+		// An unreachable cluster must not keep the others from being
+		// cleaned.
+		if err := a.collectClusterGarbage(ctx, run, cluster, started); err != nil {
+			log.Printf("Failed to collect garbage in cluster %s: %v", cluster.Name, err)
+			run.report.Failures++
+		}
+	}
+
+	// A replica that stops while dispatching leaves its claimed jobs behind.
+	if cfg.DryRun {
+		log.Printf("[GC] would requeue jobs claimed before %s", run.now.Add(-staleClaimAge).Format(time.RFC3339))
+	} else if n, err := auth.RequeueStaleJobs(run.now.Add(-staleClaimAge)); err != nil {
+		log.Printf("Failed to requeue stale jobs: %v", err)
+		run.report.Failures++
+	} else if n > 0 {
+		run.report.RequeuedJobs = int(n)
+		a.Queue.Notify()
+	}
+
+	if cfg.DryRun {
+		log.Printf("[GC] would delete events sent before %s", run.now.Add(-cfg.Retention).Format(time.RFC3339))
+	} else if n, err := auth.DeleteSentOutbox(run.now.Add(-cfg.Retention)); err != nil {
+		log.Printf("Failed to delete sent events: %v", err)
+		run.report.Failures++
+	} else {
+		run.report.SentEvents = int(n)
+	}
+
+	if err := a.collectOrphanedResults(ctx, run); err != nil {
+		return run.report, err
+	}
+	return run.report, nil
+}
+
+// collectClusterGarbage cleans up the detector Jobs and pods of cluster and
+// fails the started jobs that ran there but whose Job is gone or was
+// rejected by the DetectionJob controller.
+func (a *App) collectClusterGarbage(ctx context.Context, run *gcRun, cluster *kubeapi.Cluster, started []auth.Job) error {
+	// Deleting a DetectionJob deletes its Job too, so Jobs are deleted
+	// through the executor.
+	var kc *kubeapi.KubeClient
+	var detectionJobs []kubeapi.DetectionJob
+	switch e := cluster.Executor.(type) {
+	case *kubeapi.KubeClient:
+		kc = e
+	case kubeapi.DetectionJobClient:
+		kc = e.KubeClient
+		var err error
+		if detectionJobs, err = e.ListDetectionJobs(ctx, cluster.Namespace); err != nil {
+			return fmt.Errorf("listing DetectionJobs: %w", err)
+		}
+	case *localexec.Executor:
+		// Local executors clean up after themselves, but their jobs die
+		// with the server. Only the replica that started a job knows it,
+		// so the local executor supports a single replica.
+		a.failLostJobs(run, cluster, started, e.Has, "detector process stopped with the server")
+		return nil
+	default:
+		return nil
+	}
+	jobs, err := kc.ListJobs(ctx, cluster.Namespace)
+	if err != nil {
+		return fmt.Errorf("listing cluster jobs: %w", err)
+	}
+	pods, err := kc.ListPods(ctx, cluster.Namespace)
+	if err != nil {
+		return fmt.Errorf("listing pods: %w", err)
+	}
+	ranHere := func(job *auth.Job) bool {
+		c, ok := a.Clusters.Get(job.Cluster)
+		return ok && c == cluster
+	}
+
+	live := map[string]bool{}
+	for i := range jobs {
+		job := &jobs[i]
+		live[job.Name] = true
+		// DetectionJobs created outside the server are left to their owners.
+		if job.Labels[kubeapi.LabelUploadID] == "" {
+			continue
+		}
+		record, err := auth.GetJobByUpload(job.Labels[kubeapi.LabelUploadID])
+		if err != nil && !errors.Is(err, auth.ErrJobNotFound) {
+			return err
+		}
+
+		if record == nil || !ranHere(record) || (record.JobName != "" && record.JobName != job.Name) {
+			if run.now.Sub(job.CreationTimestamp.Time) < gcGracePeriod {
+				continue
+			}
+			run.remove(&run.report.OrphanedJobs, "orphaned job "+job.Name, func() error {
+				return cluster.Executor.DeleteJob(job.Name, cluster.Namespace)
+			})
+			continue
+		}
+		finishedAt, finished := kubeapi.JobFinishedAt(job)
+		// Jobs the server has not seen finish yet keep their state for it.
+		if !finished || !kubeapi.JobState(record.State).Terminal() || run.now.Sub(finishedAt) < run.cfg.Retention {
+			continue
+		}
+		run.remove(&run.report.FinishedJobs, "finished job "+job.Name, func() error {
+			if _, err := a.Storage.Stat(ctx, logKey(record.ID, record.PodName)); errors.Is(err, storage.ErrNotFound) {
+				a.archiveLogs(record, record.PodName)
+			}
+			return cluster.Executor.DeleteJob(job.Name, cluster.Namespace)
+		})
+	}
+
+	// DetectionJobs with a Job were handled with it. The others wait for the
+	// controller, which may have rejected them.
+	pending := map[string]bool{}
+	for i := range detectionJobs {
+		dj := &detectionJobs[i]
+		if live[dj.Name] || dj.Spec.UploadID == "" {
+			continue
+		}
+		pending[dj.Name] = true
+		record, err := auth.GetJobByUpload(dj.Spec.UploadID)
+		if err != nil && !errors.Is(err, auth.ErrJobNotFound) {
+			return err
+		}
+
+		switch {
+		case record == nil || !ranHere(record) || (record.JobName != "" && record.JobName != dj.Name),
+			kubeapi.JobState(record.State).Terminal():
+			// Nothing waits for its Job any more.
+			if run.now.Sub(dj.CreationTimestamp.Time) < gcGracePeriod {
+				continue
+			}
+			run.remove(&run.report.OrphanedJobs, "orphaned DetectionJob "+dj.Name, func() error {
+				return cluster.Executor.DeleteJob(dj.Name, cluster.Namespace)
+			})
+		case dj.Rejected():
+			run.remove(&run.report.RejectedJobs, "rejected DetectionJob "+dj.Name, func() error {
+				reason, message := dj.Status.Reason, dj.Status.Message
+				if reason == "" {
+					reason = kubeapi.ReasonInvalidSpec
+				}
+				rejected := detectionFinishedEvents(record, kubeapi.JobFailed, reason, message)
+				ok, err := auth.FailLostJob(record.ID, dj.Name, reason, message, a.outbox(rejected...)...)
+				if err != nil {
+					return err
+				}
+				if ok {
+					a.Queue.Notify()
+					a.announce(rejected...)
+				}
+				return cluster.Executor.DeleteJob(dj.Name, cluster.Namespace)
+			})
+		}
+	}
+
+	for i := range pods {
+		pod := &pods[i]
+		if live[kubeapi.OwningJob(pod)] || run.now.Sub(pod.CreationTimestamp.Time) < gcGracePeriod {
+			continue
+		}
+		run.remove(&run.report.OrphanedPods, "orphaned pod "+pod.Name, func() error {
+			return kc.DeletePod(pod.Name, cluster.Namespace)
+		})
+	}
+
+	a.failLostJobs(run, cluster, started, func(name string) bool {
+		return live[name] || pending[name]
+	}, "detector job disappeared from the cluster")
+	return nil
+}
+
+// failLostJobs fails the started jobs of cluster whose Job exists no longer,
+// giving message as the reason.
+func (a *App) failLostJobs(run *gcRun, cluster *kubeapi.Cluster, started []auth.Job, exists func(name string) bool, message string) {
+	for _, job := range started {
+		c, ok := a.Clusters.Get(job.Cluster)
+		if !ok || c != cluster || exists(job.JobName) || run.now.Sub(job.UpdatedAt) < gcGracePeriod {
+			continue
+		}
+		run.remove(&run.report.LostJobs, "record of lost job "+job.JobName, func() error {
+			lost := detectionFinishedEvents(&job, kubeapi.JobFailed, kubeapi.ReasonLost, "its detector job disappeared")
+			ok, err := auth.FailLostJob(job.ID, job.JobName, kubeapi.ReasonLost, message, a.outbox(lost...)...)
+			if ok {
+				a.Queue.Notify()
+				a.announce(lost...)
+			}
+			return err
+		})
+	}
+}
+
+// collectOrphanedResults deletes the detection results stored next to
+// uploads that no longer exist.
+func (a *App) collectOrphanedResults(ctx context.Context, run *gcRun) error {
+	paths, err := auth.ListStoredPaths()
+	if err != nil {
+		return fmt.Errorf("listing stored uploads: %w", err)
+	}
+	// Top-level directories holding uploads are never legacy results, even
+	// when a username ends in "-detected".
+	uploadDirs, uploadRoots := map[string]bool{}, map[string]bool{}
+	for _, p := range paths {
+		uploadDirs[path.Dir(p)] = true
+		root, _, _ := strings.Cut(p, "/")
+		uploadRoots[root] = true
+	}
+
+	objects, err := a.Storage.List(ctx, "")
+	if err != nil {
+		return fmt.Errorf("listing stored objects: %w", err)
+	}
+	keys := map[string]bool{}
+	for _, obj := range objects {
+		keys[obj.Key] = true
+	}
+	for _, obj := range objects {
+		var orphaned bool
+		if dir, ok := resultsOwner(obj.Key); ok {
+			orphaned = !uploadDirs[dir]
+		} else if dir, upload, ok := legacyResultsOwner(obj.Key); ok {
+			orphaned = !keys[upload] && !uploadRoots[dir]
+		}
+		// Recent results may belong to an upload created after the listing.
+		if !orphaned || run.now.Sub(obj.ModTime) < run.cfg.Retention {
+			continue
+		}
+		run.remove(&run.report.OrphanedResults, "orphaned result "+obj.Key, func() error {
+			return a.Storage.Delete(ctx, obj.Key)
+		})
+	}
+	return nil
+}
+
+// resultsOwner returns the directory of the upload whose results key belongs
+// to, see detectedDir and resultsArchive.
+func resultsOwner(key string) (string, bool) {
+	parts := strings.Split(key, "/")
+	for i := 1; i < len(parts)-1; i++ {
+		if parts[i] == "detected" {
+			return path.Join(parts[:i]...), true
+		}
+	}
+	if i := len(parts) - 1; i > 0 && parts[i] == "detected.tar.gz" {
+		return path.Join(parts[:i]...), true
+	}
+	return "", false
+}
+
+// legacyResultsOwner returns the upload whose results key belongs to for
+// results the detector wrote to "<upload>-detected" before uploads were
+// recorded in the database.
+func legacyResultsOwner(key string) (dir, upload string, ok bool) {
+	dir, rest, ok := strings.Cut(key, "/")
+	if !ok || rest == "" {
+		return "", "", false
+	}
+	upload, ok = strings.CutSuffix(dir, "-detected")
+	return dir, upload, ok
+}
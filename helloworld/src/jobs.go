@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	auth "helloworld/db"
+	"helloworld/kubeapi"
+)
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
+
+// lookupJob loads the job named in the request path. Jobs of other users are
+// reported as not found.
+func lookupJob(w http.ResponseWriter, r *http.Request) (*auth.Job, bool) {
+	user, ok := currentUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	id := r.PathValue("id")
+	job, err := auth.GetJob(id)
+	if errors.Is(err, auth.ErrJobNotFound) || (err == nil && !user.CanAccess(job.Owner)) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to get job %s: %v", id, err)
+		http.Error(w, "Unable to get job", http.StatusInternalServerError)
+		return nil, false
+	}
+	return job, true
+}
+
+// @Summary List Jobs
+// @Description Returns the detection jobs of the caller, newest first. Admins see every job.
+// @Produce json
+// @Success 200 {array} db.Job
+// @Failure 500 {string} string "Internal server error"
+// @Router /api/v1/jobs [get]
+func (a *App) listJobs(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	owner := user.Username
+	if user.IsAdmin {
+		owner = ""
+	}
+	jobs, err := auth.ListJobs(owner)
+	if err != nil {
+		log.Printf("Failed to list jobs: %v", err)
+		http.Error(w, "Unable to list jobs", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// @Summary Get Job
+// @Description Returns the state of a single detection job.
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 200 {object} db.Job
+// @Failure 404 {string} string "Job not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /api/v1/jobs/{id} [get]
+func (a *App) getJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := lookupJob(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// @Summary Cancel Job
+// @Description Cancels a detection job by deleting its pod.
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 200 {object} db.Job
+// @Failure 404 {string} string "Job not found"
+// @Failure 409 {string} string "Job already finished"
+// @Failure 500 {string} string "Internal server error"
+// @Router /api/v1/jobs/{id} [delete]
+func (a *App) cancelJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := lookupJob(w, r)
+	if !ok {
+		return
+	}
+	if kubeapi.JobState(job.State).Terminal() {
+		http.Error(w, "Job already finished", http.StatusConflict)
+		return
+	}
+
+	if job.JobName != "" {
+		a.archiveLogs(job, job.PodName)
+		if err := a.deleteClusterJob(job, job.JobName); err != nil {
+			http.Error(w, "Unable to delete job", http.StatusInternalServerError)
+			return
+		}
+	}
+	err := auth.SetJobState(job.ID, string(kubeapi.JobCancelled), "cancelled by user")
+	if errors.Is(err, auth.ErrJobFinished) {
+		// The job finished while its cluster Job was being deleted.
+		http.Error(w, "Job already finished", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to mark job %s cancelled: %v", job.ID, err)
+		http.Error(w, "Unable to update job", http.StatusInternalServerError)
+		return
+	}
+
+	job, err = auth.GetJob(job.ID)
+	if err != nil {
+		http.Error(w, "Unable to get job", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
@@ -0,0 +1,231 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	kafkasg "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"gopkg.in/yaml.v3"
+)
+
+// A támogatott SASL mechanizmusok.
+const (
+	SASLPlain       = "plain"
+	SASLScramSHA256 = "scram-sha-256"
+	SASLScramSHA512 = "scram-sha-512"
+)
+
+// Config a Kafka kapcsolat beállításai. A nulla értékű mezők helyére a
+// Validate az alapértelmezéseket írja.
+type Config struct {
+	Brokers  []string `yaml:"brokers"`
+	ClientID string   `yaml:"client_id"`
+	GroupID  string   `yaml:"group_id"`
+	// DefaultTopic kapja azokat az eseményeket, amelyek típusához a Topics
+	// nem rendel témát.
+	DefaultTopic string            `yaml:"default_topic"`
+	Topics       map[string]string `yaml:"topics"`
+	SASL         SASLConfig        `yaml:"sasl"`
+	TLS          TLSConfig         `yaml:"tls"`
+	// Compression: none, gzip, snappy, lz4 vagy zstd.
+	Compression string `yaml:"compression"`
+	// BatchSize a writer egy kérésben küldött üzeneteinek legnagyobb száma.
+	BatchSize int `yaml:"batch_size"`
+	// Acks: none, leader vagy all.
+	Acks string `yaml:"acks"`
+	// DeadLetterTopic kapja azokat az üzeneteket, amelyeket a consumer
+	// MaxAttempts kísérlet után sem tudott feldolgozni, lásd ConsumeMessages.
+	DeadLetterTopic string `yaml:"dead_letter_topic"`
+	MaxAttempts     int    `yaml:"max_attempts"`
+	// RetryBackoff az első újrapróbálkozás előtti várakozás. Minden további
+	// előtt a kétszeresére nő, legfeljebb MaxRetryBackoff-ra.
+	RetryBackoff    time.Duration `yaml:"retry_backoff"`
+	MaxRetryBackoff time.Duration `yaml:"max_retry_backoff"`
+}
+
+// SASLConfig a SASL hitelesítés beállításai. Üres Mechanism esetén nincs
+// hitelesítés.
+type SASLConfig struct {
+	Mechanism string `yaml:"mechanism"`
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+}
+
+// TLSConfig a titkosított kapcsolat beállításai.
+type TLSConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// CAFile a brokerek tanúsítványát ellenőrző CA, üresen a rendszeré.
+	CAFile string `yaml:"ca_file"`
+	// CertFile és KeyFile a kliens tanúsítványa, ha a brokerek kérik.
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// LoadConfig beolvassa a filename YAML fájlban leírt beállításokat.
+func LoadConfig(filename string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return cfg, err
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", filename, err)
+	}
+	return cfg, nil
+}
+
+// Validate kitölti az alapértelmezéseket és ellenőrzi a beállításokat.
+func (cfg *Config) Validate() error {
+	if len(cfg.Brokers) == 0 {
+		cfg.Brokers = []string{kafkaBrokerAddress}
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = defaultClientID
+	}
+	if cfg.GroupID == "" {
+		cfg.GroupID = consumerGroupID
+	}
+	if cfg.DefaultTopic == "" {
+		cfg.DefaultTopic = imageUploadTopic
+	}
+	if cfg.DeadLetterTopic == "" {
+		cfg.DeadLetterTopic = cfg.DefaultTopic + deadLetterSuffix
+	}
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.RetryBackoff == 0 {
+		cfg.RetryBackoff = defaultRetryBackoff
+	}
+	if cfg.MaxRetryBackoff == 0 {
+		cfg.MaxRetryBackoff = defaultMaxRetryBackoff
+	}
+	if _, err := cfg.compression(); err != nil {
+		return err
+	}
+	if _, err := cfg.requiredAcks(); err != nil {
+		return err
+	}
+	if cfg.BatchSize < 0 {
+		return errors.New("batch_size must not be negative")
+	}
+	if cfg.MaxAttempts < 0 || cfg.RetryBackoff < 0 || cfg.MaxRetryBackoff < 0 {
+		return errors.New("max_attempts, retry_backoff and max_retry_backoff must not be negative")
+	}
+	for _, topic := range cfg.allTopics() {
+		if topic == cfg.DeadLetterTopic {
+			return fmt.Errorf("dead_letter_topic %s is also consumed", topic)
+		}
+	}
+	switch cfg.SASL.Mechanism {
+	case "":
+	case SASLPlain, SASLScramSHA256, SASLScramSHA512:
+		if cfg.SASL.Username == "" {
+			return fmt.Errorf("sasl mechanism %s needs a username", cfg.SASL.Mechanism)
+		}
+	default:
+		return fmt.Errorf("unknown sasl mechanism %q", cfg.SASL.Mechanism)
+	}
+	if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+		return errors.New("tls cert_file and key_file must be set together")
+	}
+	return nil
+}
+
+// Topic visszaadja az eventType típusú események témáját.
+func (cfg *Config) Topic(eventType string) string {
+	if topic, ok := cfg.Topics[eventType]; ok && topic != "" {
+		return topic
+	}
+	return cfg.DefaultTopic
+}
+
+// allTopics a consumer által olvasott témák, ismétlődés nélkül.
+func (cfg *Config) allTopics() []string {
+	seen := map[string]bool{cfg.DefaultTopic: true}
+	for _, topic := range cfg.Topics {
+		if topic != "" {
+			seen[topic] = true
+		}
+	}
+	topics := make([]string, 0, len(seen))
+	for topic := range seen {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+func (cfg *Config) compression() (kafkasg.Compression, error) {
+	switch cfg.Compression {
+	case "", "none":
+		return 0, nil
+	case "gzip":
+		return kafkasg.Gzip, nil
+	case "snappy":
+		return kafkasg.Snappy, nil
+	case "lz4":
+		return kafkasg.Lz4, nil
+	case "zstd":
+		return kafkasg.Zstd, nil
+	}
+	return 0, fmt.Errorf("unknown compression %q", cfg.Compression)
+}
+
+func (cfg *Config) requiredAcks() (kafkasg.RequiredAcks, error) {
+	switch cfg.Acks {
+	case "", "all":
+		return kafkasg.RequireAll, nil
+	case "leader":
+		return kafkasg.RequireOne, nil
+	case "none":
+		return kafkasg.RequireNone, nil
+	}
+	return 0, fmt.Errorf("unknown acks %q", cfg.Acks)
+}
+
+func (cfg *Config) saslMechanism() (sasl.Mechanism, error) {
+	switch cfg.SASL.Mechanism {
+	case SASLPlain:
+		return plain.Mechanism{Username: cfg.SASL.Username, Password: cfg.SASL.Password}, nil
+	case SASLScramSHA256:
+		return scram.Mechanism(scram.SHA256, cfg.SASL.Username, cfg.SASL.Password)
+	case SASLScramSHA512:
+		return scram.Mechanism(scram.SHA512, cfg.SASL.Username, cfg.SASL.Password)
+	}
+	return nil, nil
+}
+
+func (cfg *Config) tlsConfig() (*tls.Config, error) {
+	if !cfg.TLS.Enabled {
+		return nil, nil
+	}
+	tlsCfg := &tls.Config{InsecureSkipVerify: cfg.TLS.InsecureSkipVerify}
+	if cfg.TLS.CAFile != "" {
+		pem, err := os.ReadFile(cfg.TLS.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.RootCAs = x509.NewCertPool()
+		if !tlsCfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", cfg.TLS.CAFile)
+		}
+	}
+	if cfg.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
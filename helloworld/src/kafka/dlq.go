@@ -0,0 +1,213 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	kafkasg "github.com/segmentio/kafka-go"
+)
+
+// A DeadLetterTopic üzeneteinek fejlécei: honnan származik az üzenet, és
+// miért nem sikerült feldolgozni.
+const (
+	HeaderOriginalTopic     = "dlq-original-topic"
+	HeaderOriginalPartition = "dlq-original-partition"
+	HeaderOriginalOffset    = "dlq-original-offset"
+	HeaderConsumerGroup     = "dlq-consumer-group"
+	HeaderError             = "dlq-error"
+	HeaderAttempts          = "dlq-attempts"
+	HeaderFailedAt          = "dlq-failed-at"
+
+	headerPrefix = "dlq-"
+	// replayGroupSuffix a ReplayDeadLetters consumer csoportjának utótagja.
+	replayGroupSuffix = "-dlq-replay"
+)
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent jelzi, hogy a messageHandler hibája újrapróbálkozással sem múlna
+// el, például mert az üzenet hibás. Az ilyen üzenet azonnal a
+// DeadLetterTopic-ba kerül.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// process legfeljebb MaxAttempts-szor hívja meg a handlert az üzenettel,
+// egyre hosszabb szünetekkel. Ha egyik kísérlet sem sikerül, az üzenetet a
+// hibával együtt a DeadLetterTopic-ba küldi, és ezt addig próbálja, amíg
+// sikerül. Csak akkor ad vissza hibát, ha a ctx előbb megszakad.
+func (mk *MyKafka) process(ctx context.Context, msg kafkasg.Message, handler func(key, value []byte) error) error {
+	var handlerErr error
+	attempts := 0
+	backoff := mk.cfg.RetryBackoff
+	for {
+		attempts++
+		handlerErr = handler(msg.Key, msg.Value)
+		if handlerErr == nil {
+			return nil
+		}
+		var permanent permanentError
+		if errors.As(handlerErr, &permanent) || attempts >= mk.cfg.MaxAttempts {
+			break
+		}
+		log.Printf("Hiba az üzenet feldolgozásakor (Kulcs: %s, Offset: %d, %d. kísérlet): %v. Újrapróbálkozás %s múlva...",
+			string(msg.Key), msg.Offset, attempts, handlerErr, backoff)
+		if err := sleep(ctx, backoff); err != nil {
+			return err
+		}
+		backoff = min(backoff*2, mk.cfg.MaxRetryBackoff)
+	}
+
+	log.Printf("Az üzenet (Kulcs: %s, Offset: %d) %d kísérlet után sem dolgozható fel, a %s témába kerül: %v",
+		string(msg.Key), msg.Offset, attempts, mk.cfg.DeadLetterTopic, handlerErr)
+	backoff = mk.cfg.RetryBackoff
+	for {
+		err := mk.deadLetter(ctx, msg, attempts, handlerErr)
+		if err == nil {
+			return nil
+		}
+		log.Printf("Hiba az üzenet %s témába küldésekor: %v. Újrapróbálkozás %s múlva...", mk.cfg.DeadLetterTopic, err, backoff)
+		if err := sleep(ctx, backoff); err != nil {
+			return err
+		}
+		backoff = min(backoff*2, mk.cfg.MaxRetryBackoff)
+	}
+}
+
+// deadLetter a DeadLetterTopic-ba küldi az msg üzenetet a feldolgozás
+// hibájával és az eredeti helyével a fejlécekben.
+func (mk *MyKafka) deadLetter(ctx context.Context, msg kafkasg.Message, attempts int, handlerErr error) error {
+	if mk.writer == nil {
+		if err := mk.InitWriter(); err != nil {
+			return err
+		}
+	}
+	headers := append(withoutDeadLetterHeaders(msg.Headers),
+		kafkasg.Header{Key: HeaderOriginalTopic, Value: []byte(msg.Topic)},
+		kafkasg.Header{Key: HeaderOriginalPartition, Value: []byte(strconv.Itoa(msg.Partition))},
+		kafkasg.Header{Key: HeaderOriginalOffset, Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		kafkasg.Header{Key: HeaderConsumerGroup, Value: []byte(mk.cfg.GroupID)},
+		kafkasg.Header{Key: HeaderError, Value: []byte(handlerErr.Error())},
+		kafkasg.Header{Key: HeaderAttempts, Value: []byte(strconv.Itoa(attempts))},
+		kafkasg.Header{Key: HeaderFailedAt, Value: []byte(time.Now().UTC().Format(time.RFC3339))},
+	)
+	return mk.writer.WriteMessages(ctx, kafkasg.Message{
+		Topic:   mk.cfg.DeadLetterTopic,
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+	})
+}
+
+// ReplayDeadLetters visszaküldi a DeadLetterTopic üzeneteit az eredeti
+// témájukba, és visszaadja a számukat. Akkor tér vissza, ha idle ideig nem
+// érkezik újabb üzenet. A visszaküldött üzeneteket a <GroupID>-dlq-replay
+// csoport commitálja, így egy újabb futás csak az azóta érkezetteket küldi
+// vissza. Az eredeti témát minden replika csoportja újra olvassa, tehát az
+// üzenetet azok is újra megkapják, amelyek korábban feldolgozták. Ugyanezért
+// egy hibás üzenetet minden replika a DeadLetterTopic-ba küld; ezek közül egy
+// futás az eredeti téma, partíció és offset alapján csak az elsőt küldi vissza.
+func (mk *MyKafka) ReplayDeadLetters(ctx context.Context, idle time.Duration) (int, error) {
+	if mk.writer == nil {
+		if err := mk.InitWriter(); err != nil {
+			return 0, err
+		}
+	}
+	r := kafkasg.NewReader(kafkasg.ReaderConfig{
+		Brokers:     mk.cfg.Brokers,
+		GroupID:     mk.cfg.GroupID + replayGroupSuffix,
+		Topic:       mk.cfg.DeadLetterTopic,
+		Dialer:      mk.dialer,
+		MaxWait:     time.Second,
+		StartOffset: kafkasg.FirstOffset, // Az első futás a téma minden üzenetét visszaküldi
+		ErrorLogger: kafkasg.LoggerFunc(func(format string, args ...interface{}) { log.Printf("KAFKA-REPLAY-ERROR: "+format, args...) }),
+	})
+	defer r.Close()
+
+	replayed := 0
+	seen := map[string]bool{}
+	for {
+		fetchCtx, cancel := context.WithTimeout(ctx, idle)
+		msg, err := r.FetchMessage(fetchCtx)
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return replayed, nil
+		}
+		if err != nil {
+			return replayed, err
+		}
+
+		topic := headerValue(msg.Headers, HeaderOriginalTopic)
+		origin := topic + "/" + headerValue(msg.Headers, HeaderOriginalPartition) + "/" + headerValue(msg.Headers, HeaderOriginalOffset)
+		if headerValue(msg.Headers, HeaderOriginalOffset) != "" && seen[origin] {
+			// Egy másik replika csoportja is ide küldte.
+			if err := r.CommitMessages(ctx, msg); err != nil {
+				return replayed, fmt.Errorf("committing offset %d: %w", msg.Offset, err)
+			}
+			log.Printf("A %s üzenet már vissza lett küldve, a másolata kimarad (Kulcs: %s)", origin, string(msg.Key))
+			continue
+		}
+		seen[origin] = true
+		if topic == "" {
+			topic = mk.cfg.DefaultTopic
+		}
+		err = mk.writer.WriteMessages(ctx, kafkasg.Message{
+			Topic:   topic,
+			Key:     msg.Key,
+			Value:   msg.Value,
+			Headers: withoutDeadLetterHeaders(msg.Headers),
+		})
+		if err != nil {
+			return replayed, fmt.Errorf("replaying offset %d to %s: %w", msg.Offset, topic, err)
+		}
+		if err := r.CommitMessages(ctx, msg); err != nil {
+			return replayed, fmt.Errorf("committing offset %d: %w", msg.Offset, err)
+		}
+		log.Printf("Üzenet visszaküldve a %s témába (Kulcs: %s, Hiba: %s)", topic, string(msg.Key), headerValue(msg.Headers, HeaderError))
+		replayed++
+	}
+}
+
+// withoutDeadLetterHeaders visszaadja a headers közül azokat, amelyeket nem a
+// deadLetter írt, így egy újra elbukó üzenet fejlécei nem halmozódnak.
+func withoutDeadLetterHeaders(headers []kafkasg.Header) []kafkasg.Header {
+	var kept []kafkasg.Header
+	for _, h := range headers {
+		if !strings.HasPrefix(h.Key, headerPrefix) {
+			kept = append(kept, h)
+		}
+	}
+	return kept
+}
+
+func headerValue(headers []kafkasg.Header, key string) string {
+	for _, h := range headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// sleep d ideig vár, vagy amíg a ctx megszakad.
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
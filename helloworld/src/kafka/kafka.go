@@ -3,29 +3,93 @@ package kafka
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
 	kafkasg "github.com/segmentio/kafka-go" // Átnevezve kafkasg-re az egyértelműség kedvéért
 )
 
+// Az alapértelmezések, ha a Config mást nem ad meg.
 const (
 	kafkaBrokerAddress = "my-kafka:9092"
 	imageUploadTopic   = "image-upload"
 	consumerGroupID    = "my-groupid"
+	defaultClientID    = "detector"
+
+	deadLetterSuffix       = ".dlq"
+	defaultMaxAttempts     = 5
+	defaultRetryBackoff    = 500 * time.Millisecond
+	defaultMaxRetryBackoff = 10 * time.Second
 )
 
 type MyKafka struct {
 	writer *kafkasg.Writer
 	reader *kafkasg.Reader
 	wg     sync.WaitGroup
+
+	cfg       Config
+	dialer    *kafkasg.Dialer
+	transport *kafkasg.Transport
+	// outboxWake jelzi a RelayOutbox-nak az új eseményeket, lásd NotifyOutbox.
+	outboxWake chan struct{}
 }
 
-// NewMyKafka létrehoz egy új MyKafka példányt.
-func (mk *MyKafka) NewMyKafka() *MyKafka {
-	return &MyKafka{}
+// NewMyKafka létrehoz egy új MyKafka példányt a cfg beállításaival, amelyeket
+// előbb ellenőriz. Ha minden replikának saját GroupID-ja van, mindegyik
+// megkapja az összes üzenetet.
+func NewMyKafka(cfg Config) (*MyKafka, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	mechanism, err := cfg.saslMechanism()
+	if err != nil {
+		return nil, fmt.Errorf("sasl: %w", err)
+	}
+	tlsCfg, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	return &MyKafka{
+		cfg:        cfg,
+		outboxWake: make(chan struct{}, 1),
+		dialer: &kafkasg.Dialer{
+			ClientID:      cfg.ClientID,
+			Timeout:       10 * time.Second,
+			DualStack:     true,
+			SASLMechanism: mechanism,
+			TLS:           tlsCfg,
+		},
+		transport: &kafkasg.Transport{
+			ClientID: cfg.ClientID,
+			SASL:     mechanism,
+			TLS:      tlsCfg,
+		},
+	}, nil
+}
+
+// Check ellenőrzi, hogy legalább egy broker elérhető, és a hitelesítés
+// sikeres: lekéri tőle a fürt brokereinek listáját.
+func (mk *MyKafka) Check(ctx context.Context) error {
+	var errs []error
+	for _, broker := range mk.cfg.Brokers {
+		conn, err := mk.dialer.DialContext(ctx, "tcp", broker)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", broker, err))
+			continue
+		}
+		_, err = conn.Brokers()
+		conn.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", broker, err))
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("no Kafka broker reachable: %w", errors.Join(errs...))
 }
 
 // InitWriter inicializálja a Kafka writert (producer).
@@ -34,26 +98,31 @@ func (mk *MyKafka) InitWriter() error {
 		return nil // Már inicializálva
 	}
 
-	// A writer konfigurálása
-	// További opciókért lásd: https://pkg.go.dev/github.com/segmentio/kafka-go#WriterConfig
-	w := kafkasg.NewWriter(kafkasg.WriterConfig{
-		Brokers: []string{kafkaBrokerAddress},
-		Topic:   imageUploadTopic, // Alapértelmezett téma ehhez a writerhez
-		// Balancer: &kafkasg.LeastBytes{}, // Példa: LeastBytes balancer használata
-		// RequiredAcks: kafkasg.RequireAll, // Példa: Várakozás minden replikára
-		// Async: false, // Alapértelmezetten false (szinkron)
-		Logger:      kafkasg.LoggerFunc(func(format string, args ...interface{}) { log.Printf("KAFKA-WRITER-INFO: "+format, args...) }),
-		ErrorLogger: kafkasg.LoggerFunc(func(format string, args ...interface{}) { log.Printf("KAFKA-WRITER-ERROR: "+format, args...) }),
-		MaxAttempts: 3, // Üzenetküldési kísérletek száma hiba esetén
-	})
+	// A writer konfigurálása. A téma üzenetenként, az esemény típusa szerint
+	// dől el, lásd SendMessage.
+	// További opciókért lásd: https://pkg.go.dev/github.com/segmentio/kafka-go#Writer
+	compression, _ := mk.cfg.compression()
+	acks, _ := mk.cfg.requiredAcks()
+	w := &kafkasg.Writer{
+		Addr:         kafkasg.TCP(mk.cfg.Brokers...),
+		Transport:    mk.transport,
+		Balancer:     &kafkasg.Hash{}, // Az azonos kulcsú üzenetek ugyanabba a partícióba, sorrendben kerülnek
+		Compression:  compression,
+		BatchSize:    mk.cfg.BatchSize,
+		RequiredAcks: acks,
+		Logger:       kafkasg.LoggerFunc(func(format string, args ...interface{}) { log.Printf("KAFKA-WRITER-INFO: "+format, args...) }),
+		ErrorLogger:  kafkasg.LoggerFunc(func(format string, args ...interface{}) { log.Printf("KAFKA-WRITER-ERROR: "+format, args...) }),
+		MaxAttempts:  3, // Üzenetküldési kísérletek száma hiba esetén
+	}
 
 	mk.writer = w
-	log.Println("Kafka writer (producer) inicializálva a következő témához:", imageUploadTopic)
+	log.Println("Kafka writer (producer) inicializálva a következő brokerekhez:", strings.Join(mk.cfg.Brokers, ","))
 	return nil
 }
 
-// SendMessage üzenetet küld a konfigurált Kafka témába.
-func (mk *MyKafka) SendMessage(ctx context.Context, key, value []byte) error {
+// SendMessage üzenetet küld az eventType típusú események témájába, lásd
+// Config.Topic.
+func (mk *MyKafka) SendMessage(ctx context.Context, eventType string, key, value []byte) error {
 	if mk.writer == nil {
 		if err := mk.InitWriter(); err != nil {
 			log.Printf("Nem sikerült inicializálni a Kafka writert: %v", err)
@@ -62,7 +131,7 @@ func (mk *MyKafka) SendMessage(ctx context.Context, key, value []byte) error {
 	}
 
 	message := kafkasg.Message{
-		// Topic: Itt felülírható a writer alapértelmezett témája, vagy ha a writernek nincs alapértelmezett témája
+		Topic: mk.cfg.Topic(eventType),
 		Key:   key,
 		Value: value,
 		// Time: time.Now(), // Opcionális: üzenet időbélyegének beállítása
@@ -112,25 +181,26 @@ func (mk *MyKafka) InitReader() error {
 	// A reader konfigurálása
 	// További opciókért lásd: https://pkg.go.dev/github.com/segmentio/kafka-go#ReaderConfig
 	r := kafkasg.NewReader(kafkasg.ReaderConfig{
-		Brokers:     []string{kafkaBrokerAddress},
-		GroupID:     consumerGroupID,
-		Topic:       imageUploadTopic,
-		MinBytes:    10e3,                // 10KB (Minimum byte-szám, amit a fetch-nek vissza kell adnia)
-		MaxBytes:    10e6,                // 10MB (Maximum byte-szám, amit a fetch-nek vissza kell adnia)
-		MaxWait:     time.Second * 1,     // Maximális várakozási idő új üzenetekre (ha a MinBytes nem teljesül)
-		StartOffset: kafkasg.FirstOffset, // "earliest"-nek felel meg: a legrégebbi offsettől kezd, ha nincs commitált offset a csoportban
-		// CommitInterval: 0, // Ha 0, akkor a ReadMessage után manuálisan kell commitálni FetchMessage/CommitMessages használatával. Alapértelmezetten (ha GroupID van) van auto-commit.
-		// Dialer: &kafkasg.Dialer{Timeout: 10 * time.Second, DualStack: true}, // Példa Dialer konfiguráció
+		Brokers:     mk.cfg.Brokers,
+		GroupID:     mk.cfg.GroupID,
+		GroupTopics: mk.cfg.allTopics(), // Minden eseménytípus témája
+		Dialer:      mk.dialer,
+		MinBytes:    10e3,               // 10KB (Minimum byte-szám, amit a fetch-nek vissza kell adnia)
+		MaxBytes:    10e6,               // 10MB (Maximum byte-szám, amit a fetch-nek vissza kell adnia)
+		MaxWait:     time.Second * 1,    // Maximális várakozási idő új üzenetekre (ha a MinBytes nem teljesül)
+		StartOffset: kafkasg.LastOffset, // "latest"-nek felel meg: egy új csoport csak az indulása után küldött üzeneteket kapja meg, a régi értesítéseket nem
+		// A CommitInterval 0, így a CommitMessages szinkron commitál, lásd ConsumeMessages.
 		Logger:      kafkasg.LoggerFunc(func(format string, args ...interface{}) { log.Printf("KAFKA-READER-INFO: "+format, args...) }),
 		ErrorLogger: kafkasg.LoggerFunc(func(format string, args ...interface{}) { log.Printf("KAFKA-READER-ERROR: "+format, args...) }),
 	})
 	mk.reader = r
-	log.Println("Kafka reader (consumer) inicializálva a következő témához:", imageUploadTopic, "és csoporthoz:", consumerGroupID)
+	log.Println("Kafka reader (consumer) inicializálva a következő témákhoz:", strings.Join(mk.cfg.allTopics(), ","), "és csoporthoz:", mk.cfg.GroupID)
 	return nil
 }
 
 // ConsumeMessages elindítja az üzenetek fogyasztását a Kafka-ból és feldolgozza őket a messageHandler segítségével.
-// Ez a függvény blokkoló, és általában egy goroutine-ban kell futtatni.
+// Egy üzenet offsete csak akkor kerül commitálásra, ha a messageHandler feldolgozta, vagy az üzenet a
+// DeadLetterTopic-ba került, lásd process. Ez a függvény blokkoló, és általában egy goroutine-ban kell futtatni.
 func (mk *MyKafka) ConsumeMessages(ctx context.Context, messageHandler func(key, value []byte) error) {
 	if mk.reader == nil {
 		if err := mk.InitReader(); err != nil {
@@ -144,53 +214,37 @@ func (mk *MyKafka) ConsumeMessages(ctx context.Context, messageHandler func(key,
 
 	log.Println("Kafka üzenetfogyasztás indítása...")
 	for {
-		// A ReadMessage blokkol, amíg egy üzenet elérhetővé nem válik, a kontextus meg nem szakad, vagy hiba nem történik.
-		msg, err := mk.reader.ReadMessage(ctx)
+		// A FetchMessage blokkol, amíg egy üzenet elérhetővé nem válik, a kontextus meg nem szakad, vagy hiba nem történik.
+		// A ReadMessage-dzsel szemben nem commitál, azt a feldolgozás után a CommitMessages végzi.
+		msg, err := mk.reader.FetchMessage(ctx)
 		if err != nil {
 			// Ha a kontextus megszakadt, a ctx.Err() nem nil lesz.
-			// A reader.Close() szintén hibát eredményez a ReadMessage-ben (gyakran io.EOF vagy context.Canceled).
+			// A reader.Close() szintén hibát eredményez a FetchMessage-ben (gyakran io.EOF vagy context.Canceled).
 			if errors.Is(err, context.Canceled) || errors.Is(err, io.EOF) {
 				log.Printf("A kontextus megszakadt vagy a reader lezárult, a consumer leáll: %v", err)
 				return // Kilépés a ciklusból
 			}
 			// Egyéb hibák kezelése (pl. átmeneti hálózati problémák)
-			// A kafka-go néhány hiba esetén automatikusan újrapróbálkozhat.
 			log.Printf("Hiba üzenet olvasásakor a Kafka-ból: %v. Újrapróbálkozás...", err)
-			// Opcionális: kis késleltetés hozzáadása az újrapróbálkozás előtt, hogy elkerüljük a szoros ciklust tartós hibák esetén
-			select {
-			case <-time.After(time.Second): // Várakozás egy másodpercet hiba esetén
-			case <-ctx.Done(): // Ha közben a kontextus megszakad
-				log.Printf("A kontextus megszakadt hiba utáni várakozás közben: %v", ctx.Err())
+			if err := sleep(ctx, time.Second); err != nil {
+				log.Printf("A kontextus megszakadt hiba utáni várakozás közben: %v", err)
 				return
 			}
 			continue
 		}
 
-		// log.Printf("Üzenet érkezett: Téma: %s, Partíció: %d, Offset: %d, Kulcs: %s, Érték: %s",
-		//	 msg.Topic, msg.Partition, msg.Offset, string(msg.Key), string(msg.Value))
-
-		if err := messageHandler(msg.Key, msg.Value); err != nil {
-			log.Printf("Hiba az üzenet feldolgozásakor (Kulcs: %s, Offset: %d): %v", string(msg.Key), msg.Offset, err)
-			// Hibakezelési stratégia eldöntése: logolás és folytatás, újra feldolgozás, vagy leállítás.
-			// Egyelőre csak logolás és folytatás.
-			// Az üzenetek automatikusan commitálódnak a ReadMessage által, ha GroupID van beállítva és a CommitInterval nem 0.
-		} else {
-			// Az üzenet sikeresen feldolgozva.
-			// Ha GroupID-t és alapértelmezett CommitInterval-t használunk (vagy egy megadottat), a commit automatikus.
-			// Manuális commithoz a segmentio/kafka-go-val (ha az auto-commit le van tiltva vagy több kontrollra van szükség):
-			// Először: `m, err := r.FetchMessage(ctx)`
-			// Majd sikeres feldolgozás után: `if err := r.CommitMessages(ctx, m); err != nil { log.Fatal(err) }`
-			// log.Printf("Üzenet feldolgozva és commitálva (Offset: %d)", msg.Offset)
-		}
-
-		// Explicit kontextus ellenőrzés a ciklusban, ha a ReadMessage nagyon hosszú ideig blokkolhatna
-		// anélkül, hogy visszatérne, bár a ReadMessage-nek átadott ctx-nek kezelnie kell a megszakítást.
-		select {
-		case <-ctx.Done():
-			log.Println("A ConsumeMessages ciklus kontextus megszakítást észlelt, leállítás.")
+		if err := mk.process(ctx, msg, messageHandler); err != nil {
+			// A commit elmarad, így az üzenetet a következő indulás után újra megkapjuk.
+			log.Printf("A kontextus megszakadt az üzenet feldolgozása közben (Offset: %d), a consumer leáll: %v", msg.Offset, err)
 			return
-		default:
-			// Folytatás a következő üzenettel
+		}
+		if err := mk.reader.CommitMessages(ctx, msg); err != nil {
+			if ctx.Err() != nil {
+				log.Printf("A kontextus megszakadt commitálás közben, a consumer leáll: %v", err)
+				return
+			}
+			// Az üzenetet legfeljebb újra megkapjuk, a feldolgozás tehát legalább egyszeri.
+			log.Printf("Hiba az offset commitálásakor (Téma: %s, Partíció: %d, Offset: %d): %v", msg.Topic, msg.Partition, msg.Offset, err)
 		}
 	}
 }
@@ -198,7 +252,7 @@ func (mk *MyKafka) ConsumeMessages(ctx context.Context, messageHandler func(key,
 // TestSendMessage egy előre definiált tesztüzenetet küld.
 func (mk *MyKafka) TestSendMessage(ctx context.Context) {
 	log.Println("Tesztüzenet küldésének kísérlete...")
-	err := mk.SendMessage(ctx, []byte("test-key"), []byte("test-value from segmentio/kafka-go"))
+	err := mk.SendMessage(ctx, "test", []byte("test-key"), []byte("test-value from segmentio/kafka-go"))
 	if err != nil {
 		log.Printf("Nem sikerült a tesztüzenet küldése: %v", err)
 	} else {
@@ -227,7 +281,7 @@ import (
 
 func main() {
 	// Inicializáljuk a Kafka klienst a csomagunkból
-	kafkaClient := kafka.NewMyKafka() // Feltételezve, hogy a MyKafka és a NewMyKafka a "kafka" csomagban van
+	kafkaClient, _ := kafka.NewMyKafka(kafka.Config{}) // Feltételezve, hogy a MyKafka és a NewMyKafka a "kafka" csomagban van
 
 	// Hozzunk létre egy kontextust, amit le tudunk állítani
 	ctx, cancel := context.WithCancel(context.Background())
@@ -245,7 +299,7 @@ func main() {
 	kafkaClient.TestSendMessage(ctx)
 
 	// Küldjünk egy másik üzenetet
-	err := kafkaClient.SendMessage(ctx, []byte("user-456"), []byte("{\"event\":\"item_purchased\", \"itemId\":\"xyz789\"}"))
+	err := kafkaClient.SendMessage(ctx, "item_purchased", []byte("user-456"), []byte("{\"event\":\"item_purchased\", \"itemId\":\"xyz789\"}"))
 	if err != nil {
 		log.Printf("Hiba egyedi üzenet küldésekor: %v", err)
 	} else {
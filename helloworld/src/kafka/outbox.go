@@ -0,0 +1,115 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	kafkasg "github.com/segmentio/kafka-go"
+)
+
+const (
+	// outboxBatchSize a relay által egyszerre küldött események legnagyobb száma.
+	outboxBatchSize = 100
+	// outboxPollInterval után a relay akkor is ránéz az outboxra, ha a
+	// NotifyOutbox nem jelzett, pl. mert egy másik replika írt bele.
+	outboxPollInterval = time.Second
+	// outboxSendTimeout egy köteg küldésének időkorlátja. Addig az outbox
+	// tranzakciója nyitva marad.
+	outboxSendTimeout = 30 * time.Second
+)
+
+// OutboxMessage egy elküldésre váró esemény az outboxban.
+type OutboxMessage struct {
+	EventType string
+	Key       []byte
+	Value     []byte
+}
+
+// OutboxStore az outbox, amelybe az események az általuk bejelentett
+// változással egy tranzakcióban kerülnek, pl. az adatbázis outbox táblája.
+type OutboxStore interface {
+	// RelayPending a legfeljebb limit legrégebbi el nem küldött eseményt a
+	// felvételük sorrendjében adja át a send-nek, és ha az sikerrel tér
+	// vissza, elküldöttnek jelöli őket. Visszaadja az elküldött események
+	// számát. Egyszerre csak egy hívás küldhet, a többi nem hívja a send-et.
+	RelayPending(limit int, send func([]OutboxMessage) error) (int, error)
+}
+
+// NotifyOutbox jelzi a RelayOutbox-nak, hogy új esemény került az outboxba.
+func (mk *MyKafka) NotifyOutbox() {
+	select {
+	case mk.outboxWake <- struct{}{}:
+	default: // A relay már tud róla
+	}
+}
+
+// RelayOutbox elküldi a store eseményeit, amíg a ctx meg nem szakad. Ha a
+// brokerek nem érhetők el, az események az outboxban maradnak, és a relay
+// RetryBackoff-tól MaxRetryBackoff-ig növekvő szünetekkel próbálkozik újra.
+// Egy köteg küldése félbeszakadhat, ekkor az eseményei újra elmennek, tehát
+// a kézbesítés legalább egyszeri. Minden replikán futhat, egyszerre közülük
+// csak az egyik küld. Ez a függvény blokkoló, és általában egy goroutine-ban
+// kell futtatni.
+func (mk *MyKafka) RelayOutbox(ctx context.Context, store OutboxStore) {
+	if mk.writer == nil {
+		if err := mk.InitWriter(); err != nil {
+			log.Printf("Nem sikerült inicializálni a Kafka writert, az outbox relay nem indul el: %v", err)
+			return
+		}
+	}
+
+	mk.wg.Add(1)
+	defer mk.wg.Done()
+
+	log.Println("Outbox relay indítása...")
+	backoff := mk.cfg.RetryBackoff
+	for {
+		wait := outboxPollInterval
+		wake := mk.outboxWake
+		n, err := store.RelayPending(outboxBatchSize, func(messages []OutboxMessage) error {
+			return mk.sendOutbox(ctx, messages)
+		})
+		switch {
+		case err != nil:
+			log.Printf("Hiba az outbox eseményeinek küldésekor: %v. Újrapróbálkozás %s múlva...", err, backoff)
+			wait = backoff
+			wake = nil // Az új események sem rövidítik le a várakozást
+			backoff = min(backoff*2, mk.cfg.MaxRetryBackoff)
+		case n == outboxBatchSize:
+			wait = 0 // Lehet még elküldetlen esemény
+			backoff = mk.cfg.RetryBackoff
+		default:
+			backoff = mk.cfg.RetryBackoff
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("Az outbox relay leáll.")
+			return
+		case <-wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// sendOutbox elküldi a messages eseményeket, mindegyiket a típusa szerinti
+// témába. Az azonos kulcsú események sorrendje megmarad.
+func (mk *MyKafka) sendOutbox(ctx context.Context, messages []OutboxMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, outboxSendTimeout)
+	defer cancel()
+
+	batch := make([]kafkasg.Message, len(messages))
+	for i, m := range messages {
+		batch[i] = kafkasg.Message{
+			Topic: mk.cfg.Topic(m.EventType),
+			Key:   m.Key,
+			Value: m.Value,
+		}
+	}
+	if err := mk.writer.WriteMessages(ctx, batch...); err != nil {
+		return fmt.Errorf("writing %d events: %w", len(batch), err)
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package kubeapi
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Strategies a Pool can pick clusters with.
+const (
+	// StrategyCapacity picks the cluster with the most free job slots.
+	StrategyCapacity = "capacity"
+	// StrategyRoundRobin takes turns among the clusters with free slots.
+	StrategyRoundRobin = "round-robin"
+)
+
+// failoverCooldown is how long an unreachable cluster is skipped.
+const failoverCooldown = time.Minute
+
+var (
+	// ErrNoCluster means no configured cluster matches a selector.
+	ErrNoCluster = errors.New("no cluster matches the selector")
+	// ErrNoCapacity means every matching cluster is full or unreachable.
+	ErrNoCapacity = errors.New("no matching cluster has capacity")
+)
+
+// ClusterConfig describes one cluster detection Jobs can run in.
+type ClusterConfig struct {
+	Name string `yaml:"name"`
+	// Executor is "kubernetes", the default, "detectionjob" to leave
+	// creating Jobs to the DetectionJob controller, or "local" to run the
+	// detector as a subprocess of the server.
+	Executor string `yaml:"executor"`
+	// Stub makes a local executor write fake results instead of running the
+	// detector.
+	Stub bool `yaml:"stub"`
+	// Kubeconfig, Context and the Secret fields are passed to NewKubeClient.
+	Kubeconfig      string `yaml:"kubeconfig"`
+	Context         string `yaml:"context"`
+	Secret          string `yaml:"secret"`
+	SecretNamespace string `yaml:"secret_namespace"`
+	SecretKey       string `yaml:"secret_key"`
+	// Namespace is where detector Jobs are created, "detector" by default.
+	Namespace string `yaml:"namespace"`
+	// PVC is the claim holding the shared upload volume, if the cluster has
+	// one.
+	PVC    string            `yaml:"pvc"`
+	Labels map[string]string `yaml:"labels"`
+	// MaxJobs caps the detector Jobs in flight on the cluster. Zero is
+	// unlimited.
+	MaxJobs int `yaml:"max_jobs"`
+}
+
+// PoolConfig lists the clusters of a Pool.
+type PoolConfig struct {
+	Strategy string          `yaml:"strategy"`
+	Clusters []ClusterConfig `yaml:"clusters"`
+}
+
+// LoadPoolConfig reads a PoolConfig from the YAML file at filename.
+func LoadPoolConfig(filename string) (*PoolConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var cfg PoolConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return &cfg, nil
+}
+
+func (cfg *PoolConfig) validate() error {
+	switch cfg.Strategy {
+	case "":
+		cfg.Strategy = StrategyCapacity
+	case StrategyCapacity, StrategyRoundRobin:
+	default:
+		return fmt.Errorf("unknown strategy %q", cfg.Strategy)
+	}
+	if len(cfg.Clusters) == 0 {
+		return errors.New("no clusters configured")
+	}
+	seen := map[string]bool{}
+	for i := range cfg.Clusters {
+		c := &cfg.Clusters[i]
+		if c.Name == "" {
+			return fmt.Errorf("cluster %d has no name", i)
+		}
+		if seen[c.Name] {
+			return fmt.Errorf("cluster %q is configured twice", c.Name)
+		}
+		seen[c.Name] = true
+		switch c.Executor {
+		case "":
+			c.Executor = ExecutorKubernetes
+		case ExecutorKubernetes, ExecutorLocal, ExecutorDetectionJob:
+		default:
+			return fmt.Errorf("cluster %q: unknown executor %q", c.Name, c.Executor)
+		}
+		if c.Namespace == "" {
+			c.Namespace = "detector"
+		}
+		if c.SecretNamespace == "" {
+			c.SecretNamespace = c.Namespace
+		}
+		if c.MaxJobs < 0 {
+			return fmt.Errorf("cluster %q: max_jobs must not be negative", c.Name)
+		}
+	}
+	return nil
+}
+
+// ClientOptions returns the options NewKubeClient connects to c with.
+func (c ClusterConfig) ClientOptions() ClientOptions {
+	return ClientOptions{
+		Kubeconfig:      c.Kubeconfig,
+		Context:         c.Context,
+		SecretName:      c.Secret,
+		SecretNamespace: c.SecretNamespace,
+		SecretKey:       c.SecretKey,
+	}
+}
+
+// Cluster is a connected cluster of a Pool.
+type Cluster struct {
+	ClusterConfig
+	Executor Executor
+	Tracker  Tracker
+
+	downUntil time.Time
+}
+
+// Matches reports whether the labels of c include selector.
+func (c *Cluster) Matches(selector map[string]string) bool {
+	for k, v := range selector {
+		if c.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Pool picks the cluster each detection Job runs in.
+type Pool struct {
+	strategy string
+	clusters []*Cluster
+
+	mu   sync.Mutex
+	next int
+}
+
+// NewPool groups clusters, the first of which is the default.
+func NewPool(strategy string, clusters []*Cluster) *Pool {
+	return &Pool{strategy: strategy, clusters: clusters}
+}
+
+// Clusters returns every cluster of the pool.
+func (p *Pool) Clusters() []*Cluster {
+	return p.clusters
+}
+
+// Get returns the cluster called name. Jobs recorded before clusters were
+// named ran in the default cluster, so an empty name selects it.
+func (p *Pool) Get(name string) (*Cluster, bool) {
+	if name == "" {
+		return p.clusters[0], true
+	}
+	for _, c := range p.clusters {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// Select picks a reachable cluster matching selector that has a free slot,
+// given the number of Jobs in flight on every cluster. Clusters in exclude
+// were already tried.
+func (p *Pool) Select(selector map[string]string, inFlight map[string]int, exclude map[string]bool) (*Cluster, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	matched := false
+	var best *Cluster
+	bestFree := 0
+	for i := range p.clusters {
+		// Round-robin starts looking after the cluster picked last.
+		c := p.clusters[(p.next+i)%len(p.clusters)]
+		if !c.Matches(selector) {
+			continue
+		}
+		matched = true
+		if exclude[c.Name] || now.Before(c.downUntil) || !c.Tracker.HasSynced() {
+			continue
+		}
+		limit := c.MaxJobs
+		if limit == 0 {
+			limit = math.MaxInt32
+		}
+		free := limit - inFlight[c.Name]
+		if free <= 0 {
+			continue
+		}
+		if p.strategy == StrategyRoundRobin {
+			best = c
+			p.next = (p.next + i + 1) % len(p.clusters)
+			break
+		}
+		if best == nil || free > bestFree {
+			best, bestFree = c, free
+		}
+	}
+	if !matched {
+		return nil, ErrNoCluster
+	}
+	if best == nil {
+		return nil, ErrNoCapacity
+	}
+	return best, nil
+}
+
+// MarkUnreachable makes the pool skip c for a while when err shows that its
+// API server could not be reached, and reports whether it did.
+func (p *Pool) MarkUnreachable(c *Cluster, err error) bool {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return false
+	}
+	p.mu.Lock()
+	c.downUntil = time.Now().Add(failoverCooldown)
+	p.mu.Unlock()
+	return true
+}
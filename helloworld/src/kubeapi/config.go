@@ -0,0 +1,105 @@
+package kubeapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const defaultSecretKey = "config"
+
+// ClientOptions tell NewKubeClient where to find the cluster.
+type ClientOptions struct {
+	// Kubeconfig is the path of a kubeconfig file to use before anything
+	// else.
+	Kubeconfig string
+	// Context selects a context of the kubeconfig file instead of its
+	// current one.
+	Context string
+
+	// SecretName, when set, names a secret holding the kubeconfig of the
+	// cluster detector Jobs run in. The configuration found first is then
+	// only used to read that secret.
+	SecretName      string
+	SecretNamespace string
+	// SecretKey is the key of the kubeconfig in the secret, "config" by
+	// default.
+	SecretKey string
+}
+
+// loadConfig tries, in order, opts.Kubeconfig, the files listed in
+// KUBECONFIG, the in-cluster configuration and ~/.kube/config.
+func loadConfig(opts ClientOptions) (*rest.Config, string, error) {
+	var tried []string
+	fromFiles := func(rules *clientcmd.ClientConfigLoadingRules) (*rest.Config, error) {
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: opts.Context}
+		return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	}
+
+	if opts.Kubeconfig != "" {
+		config, err := fromFiles(&clientcmd.ClientConfigLoadingRules{ExplicitPath: opts.Kubeconfig})
+		if err == nil {
+			return config, "kubeconfig " + opts.Kubeconfig, nil
+		}
+		// An explicitly requested file must not silently fall back.
+		return nil, "", fmt.Errorf("kubeconfig %s: %w", opts.Kubeconfig, err)
+	}
+
+	if env := os.Getenv(clientcmd.RecommendedConfigPathEnvVar); env != "" {
+		rules := &clientcmd.ClientConfigLoadingRules{Precedence: strings.Split(env, string(os.PathListSeparator))}
+		config, err := fromFiles(rules)
+		if err == nil {
+			return config, "KUBECONFIG " + env, nil
+		}
+		tried = append(tried, fmt.Sprintf("KUBECONFIG %s: %v", env, err))
+	} else {
+		tried = append(tried, "KUBECONFIG: not set")
+	}
+
+	// The in-cluster configuration has no contexts to choose from.
+	if opts.Context == "" {
+		config, err := rest.InClusterConfig()
+		if err == nil {
+			return config, "in-cluster configuration", nil
+		}
+		tried = append(tried, fmt.Sprintf("in-cluster configuration: %v", err))
+	}
+
+	home := clientcmd.RecommendedHomeFile
+	config, err := fromFiles(&clientcmd.ClientConfigLoadingRules{ExplicitPath: home})
+	if err == nil {
+		return config, "kubeconfig " + home, nil
+	}
+	tried = append(tried, fmt.Sprintf("kubeconfig %s: %v", home, err))
+
+	return nil, "", errors.New("no Kubernetes configuration found, tried " + strings.Join(tried, "; "))
+}
+
+// configFromSecret reads the kubeconfig stored in the secret opts names
+// through clientset.
+func configFromSecret(clientset kubernetes.Interface, opts ClientOptions) (*rest.Config, error) {
+	key := opts.SecretKey
+	if key == "" {
+		key = defaultSecretKey
+	}
+	secret, err := clientset.CoreV1().Secrets(opts.SecretNamespace).Get(context.Background(), opts.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("reading secret %s/%s: %w", opts.SecretNamespace, opts.SecretName, err)
+	}
+	data, ok := secret.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("secret %s/%s does not contain a '%s' key", opts.SecretNamespace, opts.SecretName, key)
+	}
+	config, err := clientcmd.RESTConfigFromKubeConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("secret %s/%s: %w", opts.SecretNamespace, opts.SecretName, err)
+	}
+	return config, nil
+}
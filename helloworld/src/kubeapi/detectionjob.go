@@ -0,0 +1,232 @@
+package kubeapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"helloworld/models"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// DetectionJobResource is the DetectionJob custom resource, see
+// deploy/kube/detectionjob-crd.yaml.
+var DetectionJobResource = schema.GroupVersionResource{
+	Group:    "detector.felhohf.io",
+	Version:  "v1alpha1",
+	Resource: "detectionjobs",
+}
+
+// Condition types of a DetectionJob.
+const (
+	// ConditionJobCreated is true once the Job of a DetectionJob exists and
+	// false when its spec cannot be run.
+	ConditionJobCreated = "JobCreated"
+	// ConditionFinished is true once the detection succeeded or failed.
+	ConditionFinished = "Finished"
+)
+
+// ReasonInvalidSpec marks DetectionJobs whose spec cannot be run.
+const ReasonInvalidSpec = "InvalidSpec"
+
+// DetectionJob asks for one detection run. The controller creates a Job of
+// the same name for it and reports its progress in Status.
+type DetectionJob struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DetectionJobSpec   `json:"spec"`
+	Status DetectionJobStatus `json:"status,omitempty"`
+}
+
+// DetectionJobSpec mirrors JobSpec. The model is named rather than spelled
+// out, so the controller must load the same registry as the server.
+type DetectionJobSpec struct {
+	// UploadID links DetectionJobs created by the server to their upload.
+	UploadID string `json:"uploadID,omitempty"`
+	// Model names a model of the registry, the default one when empty.
+	Model  string          `json:"model,omitempty"`
+	Params DetectionParams `json:"params,omitempty"`
+	Source ObjectLocation  `json:"source"`
+	// Output is where the results go. With a URL they are uploaded to it as
+	// a gzipped tar archive.
+	Output ObjectLocation `json:"output"`
+	// PVC is the claim holding Source and Output when they have no URLs.
+	PVC            string `json:"pvc,omitempty"`
+	TimeoutSeconds int64  `json:"timeoutSeconds,omitempty"`
+}
+
+// DetectionParams tune a detection. Unset fields take the model's defaults.
+type DetectionParams struct {
+	Conf    float64 `json:"conf,omitempty"`
+	IoU     float64 `json:"iou,omitempty"`
+	ImgSize int     `json:"imgSize,omitempty"`
+}
+
+// ObjectLocation is a path on the shared volume, or in storage when URL is
+// set, together with a presigned URL to transfer it through.
+type ObjectLocation struct {
+	Path string `json:"path"`
+	URL  string `json:"url,omitempty"`
+}
+
+// DetectionJobStatus is the observed state of a DetectionJob.
+type DetectionJobStatus struct {
+	Phase      JobState           `json:"phase,omitempty"`
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	JobName    string             `json:"jobName,omitempty"`
+	PodName    string             `json:"podName,omitempty"`
+	Reason     string             `json:"reason,omitempty"`
+	Message    string             `json:"message,omitempty"`
+	ExitCode   *int32             `json:"exitCode,omitempty"`
+	// Result is where the results of a successful detection are.
+	Result             string       `json:"result,omitempty"`
+	StartTime          *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime     *metav1.Time `json:"completionTime,omitempty"`
+	ObservedGeneration int64        `json:"observedGeneration,omitempty"`
+}
+
+// DetectionJobFromUnstructured decodes a DetectionJob read through the
+// dynamic client.
+func DetectionJobFromUnstructured(u *unstructured.Unstructured) (*DetectionJob, error) {
+	var dj DetectionJob
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &dj); err != nil {
+		return nil, fmt.Errorf("decoding DetectionJob %s/%s: %w", u.GetNamespace(), u.GetName(), err)
+	}
+	return &dj, nil
+}
+
+// Unstructured encodes dj for the dynamic client.
+func (dj *DetectionJob) Unstructured() (*unstructured.Unstructured, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(dj)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{Object: obj}
+	u.SetGroupVersionKind(DetectionJobResource.GroupVersion().WithKind("DetectionJob"))
+	return u, nil
+}
+
+// OwnerReference returns a reference making dj the controller of an object.
+func (dj *DetectionJob) OwnerReference() metav1.OwnerReference {
+	return *metav1.NewControllerRef(dj, DetectionJobResource.GroupVersion().WithKind("DetectionJob"))
+}
+
+// JobSpec resolves the model and parameters of dj against registry. The
+// labels and annotations of dj are not part of it, see the controller.
+func (dj *DetectionJob) JobSpec(registry *models.Registry) (JobSpec, error) {
+	model, err := registry.Get(dj.Spec.Model)
+	if err != nil {
+		return JobSpec{}, err
+	}
+	settings, err := model.Resolve(models.Settings{
+		Conf:    dj.Spec.Params.Conf,
+		IoU:     dj.Spec.Params.IoU,
+		ImgSize: dj.Spec.Params.ImgSize,
+	})
+	if err != nil {
+		return JobSpec{}, err
+	}
+	spec := JobSpec{
+		UploadID:  dj.Spec.UploadID,
+		Model:     model,
+		Settings:  settings,
+		SourceURL: dj.Spec.Source.URL,
+		ResultURL: dj.Spec.Output.URL,
+		Source:    dj.Spec.Source.Path,
+		OutputDir: dj.Spec.Output.Path,
+		PvcName:   dj.Spec.PVC,
+		Namespace: dj.Namespace,
+		Timeout:   time.Duration(dj.Spec.TimeoutSeconds) * time.Second,
+	}
+	switch {
+	case spec.Source == "":
+		return spec, errors.New("source.path is required")
+	case spec.SourceURL != "" && spec.ResultURL == "":
+		return spec, errors.New("output.url is required with source.url")
+	case spec.SourceURL == "" && (spec.PvcName == "" || spec.OutputDir == ""):
+		return spec, errors.New("pvc and output.path are required without source.url")
+	}
+	return spec, nil
+}
+
+// DetectionJobClient is an Executor that leaves creating Jobs to the
+// controller. The DetectionJob and its Job share their name, so the Jobs
+// are tracked and their logs read as if the server had created them.
+type DetectionJobClient struct {
+	*KubeClient
+}
+
+// CreateJob creates a DetectionJob for spec and returns its name.
+func (c DetectionJobClient) CreateJob(spec JobSpec) (string, error) {
+	dj := &DetectionJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        jobName(spec),
+			Namespace:   spec.Namespace,
+			Labels:      jobLabels(spec),
+			Annotations: jobAnnotations(spec),
+		},
+		Spec: DetectionJobSpec{
+			UploadID: spec.UploadID,
+			Model:    spec.Model.Name,
+			Params: DetectionParams{
+				Conf:    spec.Settings.Conf,
+				IoU:     spec.Settings.IoU,
+				ImgSize: spec.Settings.ImgSize,
+			},
+			Source:         ObjectLocation{Path: spec.Source, URL: spec.SourceURL},
+			Output:         ObjectLocation{Path: spec.OutputDir, URL: spec.ResultURL},
+			TimeoutSeconds: int64(spec.Timeout.Seconds()),
+		},
+	}
+	if spec.SourceURL == "" {
+		dj.Spec.PVC = spec.PvcName
+	}
+	u, err := dj.Unstructured()
+	if err != nil {
+		return "", err
+	}
+
+	created, err := c.Dynamic.Resource(DetectionJobResource).Namespace(spec.Namespace).Create(
+		context.Background(),
+		u,
+		metav1.CreateOptions{},
+	)
+	if apierrors.IsAlreadyExists(err) {
+		log.Printf("DetectionJob %s already exists", dj.Name)
+		return dj.Name, nil
+	}
+	if err != nil {
+		log.Printf("Cannot create DetectionJob '%s': %v", dj.Name, err)
+		return "", err
+	}
+	log.Printf("Successfully created DetectionJob: %s in namespace: %s", created.GetName(), created.GetNamespace())
+	return created.GetName(), nil
+}
+
+// DeleteJob removes a DetectionJob together with its Job and pods.
+// Deleting one that no longer exists is not an error. Jobs created before
+// the server used DetectionJobs are deleted as well.
+func (c DetectionJobClient) DeleteJob(name string, namespace string) error {
+	propagation := metav1.DeletePropagationBackground
+	err := c.Dynamic.Resource(DetectionJobResource).Namespace(namespace).Delete(
+		context.Background(),
+		name,
+		metav1.DeleteOptions{PropagationPolicy: &propagation},
+	)
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Printf("Cannot delete DetectionJob '%s': %v", name, err)
+		return err
+	}
+	if err == nil {
+		log.Printf("Deleted DetectionJob: %s in namespace: %s", name, namespace)
+	}
+	return c.KubeClient.DeleteJob(name, namespace)
+}
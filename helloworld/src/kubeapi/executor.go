@@ -0,0 +1,40 @@
+package kubeapi
+
+import (
+	"context"
+	"io"
+)
+
+// Executors a cluster can run detection Jobs with.
+const (
+	ExecutorKubernetes = "kubernetes"
+	ExecutorLocal      = "local"
+	// ExecutorDetectionJob creates DetectionJob objects, which the
+	// controller turns into Kubernetes Jobs.
+	ExecutorDetectionJob = "detectionjob"
+)
+
+// Executor runs detection Jobs. KubeClient runs them as Kubernetes Jobs,
+// DetectionJobClient through DetectionJob objects.
+type Executor interface {
+	// CreateJob starts a Job for spec and returns its name.
+	CreateJob(spec JobSpec) (string, error)
+	// DeleteJob stops and removes a Job. Deleting a Job that no longer
+	// exists is not an error.
+	DeleteJob(name string, namespace string) error
+	// StreamLogs returns the detector output of a Job's pod, or
+	// ErrPodNotFound once it is gone.
+	StreamLogs(ctx context.Context, namespace, podName string, follow bool) (io.ReadCloser, error)
+}
+
+// Tracker reports the state of the Jobs an Executor runs. JobTracker follows
+// Kubernetes Jobs.
+type Tracker interface {
+	// OnChange registers fn to be called whenever the state of a Job
+	// changes. Handlers must be registered before Run.
+	OnChange(fn func(JobStatus))
+	// Run starts tracking and blocks until the current state is known.
+	Run(ctx context.Context) error
+	// HasSynced reports whether the current state is known.
+	HasSynced() bool
+}
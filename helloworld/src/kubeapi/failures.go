@@ -0,0 +1,108 @@
+package kubeapi
+
+import (
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Failure reasons reported in JobStatus.Reason.
+const (
+	ReasonImagePull       = "ImagePull"
+	ReasonInvalidImage    = "InvalidImage"
+	ReasonContainerConfig = "ContainerConfig"
+	ReasonOOMKilled       = "OOMKilled"
+	ReasonKilled          = "Killed"
+	ReasonEvicted         = "Evicted"
+	ReasonDisrupted       = "Disrupted"
+	ReasonExitCode        = "ExitCode"
+	ReasonDeadline        = "DeadlineExceeded"
+	ReasonLost            = "Lost"
+	ReasonUnknown         = "Unknown"
+)
+
+// failure describes why a detector pod did not succeed.
+type failure struct {
+	reason    string
+	message   string
+	transient bool
+}
+
+// podDisruptionReasons are set by the kubelet or the scheduler when a pod is
+// stopped for reasons that have nothing to do with the detector itself.
+var podDisruptionReasons = map[string]bool{
+	"Preempting":               true,
+	"NodeShutdown":             true,
+	"Shutdown":                 true,
+	"Terminated":               true,
+	"NodeLost":                 true,
+	"UnexpectedAdmissionError": true,
+}
+
+// blockedFailure reports a pod that cannot start and will keep its Job
+// running until the deadline, e.g. because its image cannot be pulled.
+func blockedFailure(pod *v1.Pod) (failure, bool) {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name != detectorContainerName || cs.State.Waiting == nil {
+			continue
+		}
+		w := cs.State.Waiting
+		switch w.Reason {
+		case "ErrImagePull", "ImagePullBackOff":
+			return failure{ReasonImagePull, "the detector image could not be pulled: " + w.Message, true}, true
+		case "InvalidImageName":
+			return failure{ReasonInvalidImage, "the detector image name is invalid: " + w.Message, false}, true
+		case "CreateContainerConfigError", "CreateContainerError":
+			return failure{ReasonContainerConfig, "the detector container could not be created: " + w.Message, false}, true
+		}
+	}
+	return failure{}, false
+}
+
+// classifyFailure explains why job failed, looking at its latest pod when
+// there is one. Transient failures are worth retrying unchanged.
+func classifyFailure(cond batchv1.JobCondition, pod *v1.Pod) failure {
+	if cond.Reason == batchv1.JobReasonDeadlineExceeded {
+		return failure{ReasonDeadline, "the detection did not finish in time", false}
+	}
+	if pod == nil {
+		return failure{ReasonUnknown, cond.Message, false}
+	}
+
+	if pod.Status.Reason == "Evicted" {
+		return failure{ReasonEvicted, "the pod was evicted from its node: " + pod.Status.Message, true}
+	}
+	if podDisruptionReasons[pod.Status.Reason] {
+		return failure{ReasonDisrupted, fmt.Sprintf("the pod was stopped by the cluster (%s)", pod.Status.Reason), true}
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == v1.DisruptionTarget && c.Status == v1.ConditionTrue {
+			return failure{ReasonDisrupted, fmt.Sprintf("the pod was stopped by the cluster (%s)", c.Reason), true}
+		}
+	}
+	if f, ok := blockedFailure(pod); ok {
+		return f
+	}
+
+	for _, cs := range pod.Status.ContainerStatuses {
+		t := cs.State.Terminated
+		if cs.Name != detectorContainerName || t == nil {
+			continue
+		}
+		switch {
+		case t.Reason == "OOMKilled":
+			// A retry gets the same memory limit and would run out again.
+			return failure{ReasonOOMKilled, "the detector ran out of memory", false}
+		case t.ExitCode == 137:
+			return failure{ReasonKilled, "the detector was killed", true}
+		case t.ExitCode != 0:
+			msg := fmt.Sprintf("the detector exited with code %d", t.ExitCode)
+			if t.Message != "" {
+				msg += ": " + t.Message
+			}
+			return failure{ReasonExitCode, msg, false}
+		}
+	}
+	return failure{ReasonUnknown, cond.Message, false}
+}
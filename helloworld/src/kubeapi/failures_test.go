@@ -0,0 +1,68 @@
+package kubeapi
+
+import (
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+func terminatedPod(reason string, exitCode int32) *v1.Pod {
+	return &v1.Pod{Status: v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{{
+		Name:  detectorContainerName,
+		State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{Reason: reason, ExitCode: exitCode}},
+	}}}}
+}
+
+func waitingPod(reason string) *v1.Pod {
+	return &v1.Pod{Status: v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{{
+		Name:  detectorContainerName,
+		State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: reason}},
+	}}}}
+}
+
+func TestClassifyFailure(t *testing.T) {
+	failed := batchv1.JobCondition{Type: batchv1.JobFailed, Reason: batchv1.JobReasonBackoffLimitExceeded, Message: "backoff"}
+	tests := []struct {
+		name      string
+		cond      batchv1.JobCondition
+		pod       *v1.Pod
+		reason    string
+		transient bool
+	}{
+		{"deadline", batchv1.JobCondition{Reason: batchv1.JobReasonDeadlineExceeded}, terminatedPod("OOMKilled", 137), ReasonDeadline, false},
+		{"no pod", failed, nil, ReasonUnknown, false},
+		{"evicted", failed, &v1.Pod{Status: v1.PodStatus{Reason: "Evicted"}}, ReasonEvicted, true},
+		{"node shutdown", failed, &v1.Pod{Status: v1.PodStatus{Reason: "NodeShutdown"}}, ReasonDisrupted, true},
+		{"disruption condition", failed, &v1.Pod{Status: v1.PodStatus{Conditions: []v1.PodCondition{{
+			Type: v1.DisruptionTarget, Status: v1.ConditionTrue, Reason: "PreemptionByScheduler",
+		}}}}, ReasonDisrupted, true},
+		{"image pull", failed, waitingPod("ImagePullBackOff"), ReasonImagePull, true},
+		{"invalid image", failed, waitingPod("InvalidImageName"), ReasonInvalidImage, false},
+		{"container config", failed, waitingPod("CreateContainerConfigError"), ReasonContainerConfig, false},
+		{"oom", failed, terminatedPod("OOMKilled", 137), ReasonOOMKilled, false},
+		{"killed", failed, terminatedPod("Error", 137), ReasonKilled, true},
+		{"exit code", failed, terminatedPod("Error", 1), ReasonExitCode, false},
+		{"no container status", failed, &v1.Pod{}, ReasonUnknown, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := classifyFailure(tt.cond, tt.pod)
+			if f.reason != tt.reason || f.transient != tt.transient {
+				t.Errorf("classifyFailure = %+v, want reason %s, transient %v", f, tt.reason, tt.transient)
+			}
+			if f.message == "" && tt.reason != ReasonUnknown {
+				t.Errorf("classifyFailure gave no message")
+			}
+		})
+	}
+}
+
+func TestBlockedFailure(t *testing.T) {
+	if _, ok := blockedFailure(waitingPod("ContainerCreating")); ok {
+		t.Error("a pod that is still being created is not blocked")
+	}
+	if f, ok := blockedFailure(waitingPod("ErrImagePull")); !ok || f.reason != ReasonImagePull {
+		t.Errorf("blockedFailure = %+v, %v; want an image pull failure", f, ok)
+	}
+}
@@ -0,0 +1,108 @@
+package kubeapi
+
+import (
+	"context"
+	"log"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+var detectorSelector = labels.SelectorFromSet(labels.Set{LabelApp: AppName}).String()
+
+// ListJobs returns the detector Jobs in namespace, read from the API server
+// rather than the tracker's cache.
+func (kc *KubeClient) ListJobs(ctx context.Context, namespace string) ([]batchv1.Job, error) {
+	list, err := kc.Clientset.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{LabelSelector: detectorSelector})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// ListDetectionJobs returns the DetectionJobs the server created in
+// namespace.
+func (c DetectionJobClient) ListDetectionJobs(ctx context.Context, namespace string) ([]DetectionJob, error) {
+	list, err := c.Dynamic.Resource(DetectionJobResource).Namespace(namespace).List(ctx, metav1.ListOptions{LabelSelector: detectorSelector})
+	if err != nil {
+		return nil, err
+	}
+	djs := make([]DetectionJob, 0, len(list.Items))
+	for i := range list.Items {
+		dj, err := DetectionJobFromUnstructured(&list.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		djs = append(djs, *dj)
+	}
+	return djs, nil
+}
+
+// Rejected reports whether the controller refused to create a Job for dj.
+func (dj *DetectionJob) Rejected() bool {
+	return meta.IsStatusConditionFalse(dj.Status.Conditions, ConditionJobCreated)
+}
+
+// ListPods returns the detector pods in namespace.
+func (kc *KubeClient) ListPods(ctx context.Context, namespace string) ([]v1.Pod, error) {
+	list, err := kc.Clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: detectorSelector})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// DeletePod removes a pod. Deleting a pod that no longer exists is not an
+// error.
+func (kc *KubeClient) DeletePod(name string, namespace string) error {
+	err := kc.Clientset.CoreV1().Pods(namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("Cannot delete pod '%s': %v", name, err)
+		return err
+	}
+	log.Printf("Deleted pod: %s in namespace: %s", name, namespace)
+	return nil
+}
+
+// JobFinishedAt reports when job completed or failed.
+func JobFinishedAt(job *batchv1.Job) (time.Time, bool) {
+	for _, cond := range job.Status.Conditions {
+		if cond.Status == v1.ConditionTrue && (cond.Type == batchv1.JobComplete || cond.Type == batchv1.JobFailed) {
+			return cond.LastTransitionTime.Time, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// PodFinishedAt reports when the detector container of pod terminated.
+func PodFinishedAt(pod *v1.Pod) (time.Time, bool) {
+	if pod.Status.Phase != v1.PodSucceeded && pod.Status.Phase != v1.PodFailed {
+		return time.Time{}, false
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name == detectorContainerName && cs.State.Terminated != nil {
+			return cs.State.Terminated.FinishedAt.Time, true
+		}
+	}
+	// Pods that never ran a container, e.g. evicted ones.
+	return pod.CreationTimestamp.Time, true
+}
+
+// OwningJob returns the name of the Job that created pod, or "" for pods
+// created directly.
+func OwningJob(pod *v1.Pod) string {
+	for _, ref := range pod.OwnerReferences {
+		if ref.Kind == "Job" && ref.Controller != nil && *ref.Controller {
+			return ref.Name
+		}
+	}
+	return ""
+}
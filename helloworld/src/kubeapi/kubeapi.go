@@ -4,132 +4,298 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"path"
 	"strings"
 	"time"
 
+	"helloworld/models"
+
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 
+	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
+const (
+	AppName = "yolo-job"
+
+	// Detector Jobs, their pods and DetectionJobs carry these labels, see
+	// jobLabels, so they can be selected by job, upload, owner, model or
+	// batch.
+	LabelApp      = "app"
+	LabelUploadID = "upload-id"
+	LabelJobID    = "job-id"
+	LabelOwner    = "owner"
+	LabelModel    = "model"
+	LabelBatchID  = "batch-id"
+
+	// The annotations hold values labels cannot, such as file names.
+	AnnotationOwner    = "detector.felhohf.io/owner"
+	AnnotationFilename = "detector.felhohf.io/filename"
+
+	detectorContainerName = "main-processor"
+	dataMountPath         = "/mnt/data"
+	workMountPath         = "/work"
+)
+
+// The server retries failed detections itself, see JobStatus.Transient.
+// Finished Jobs are removed by its garbage collector.
+const jobBackoffLimit int32 = 0
+
 type KubeClient struct {
 	Clientset kubernetes.Interface
+	// Dynamic reaches the DetectionJob resource, which has no typed client.
+	Dynamic dynamic.Interface
 }
 
-func NewKubeClient() (*KubeClient, error) {
-	secretName := "detector-kubeconfig"
-	clusterConfig, err := rest.InClusterConfig()
+// NewKubeClient connects to the cluster described by opts, see loadConfig
+// for where the configuration is looked up.
+func NewKubeClient(opts ClientOptions) (*KubeClient, error) {
+	config, source, err := loadConfig(opts)
 	if err != nil {
 		return nil, err
 	}
-
-	clientset, err := kubernetes.NewForConfig(clusterConfig)
+	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, err
 	}
+	log.Printf("Connecting to Kubernetes using %s", source)
 
-	secret, err := clientset.CoreV1().Secrets("detector").Get(context.Background(), secretName, metav1.GetOptions{})
+	if opts.SecretName != "" {
+		config, err = configFromSecret(clientset, opts)
+		if err != nil {
+			return nil, err
+		}
+		clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Using kubeconfig from secret %s/%s", opts.SecretNamespace, opts.SecretName)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
 	if err != nil {
 		return nil, err
 	}
+	return &KubeClient{Clientset: clientset, Dynamic: dynamicClient}, nil
+}
 
-	kubeconfigData, ok := secret.Data["config"]
-	if !ok {
-		return nil, fmt.Errorf("secret %s/%s does not contain a 'config' key", "detector", secretName)
-	}
+// jobName returns the name of the cluster Job running spec. Every attempt of
+// a detection job gets its own name, so creating the Job of an attempt twice
+// finds the first one instead of starting another.
+func jobName(spec JobSpec) string {
+	return fmt.Sprintf("%s-%s-%d", AppName, strings.ToLower(spec.JobID), spec.Attempt)
+}
 
-	config, err := clientcmd.RESTConfigFromKubeConfig(kubeconfigData)
-	if err != nil {
-		return nil, err
+// jobLabels returns the labels of the objects running spec. Values that are
+// not valid label values, e.g. some usernames, are only kept in the
+// annotations, see jobAnnotations.
+func jobLabels(spec JobSpec) map[string]string {
+	labels := map[string]string{
+		LabelApp:      AppName,
+		LabelUploadID: spec.UploadID,
 	}
+	for key, value := range map[string]string{
+		LabelJobID:   spec.JobID,
+		LabelOwner:   spec.Owner,
+		LabelModel:   spec.Model.Name,
+		LabelBatchID: spec.BatchID,
+	} {
+		if value != "" && len(validation.IsValidLabelValue(value)) == 0 {
+			labels[key] = value
+		}
+	}
+	return labels
+}
 
-	clientset, err = kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, err
+// jobAnnotations returns the annotations of the objects running spec.
+func jobAnnotations(spec JobSpec) map[string]string {
+	annotations := map[string]string{}
+	if spec.Owner != "" {
+		annotations[AnnotationOwner] = spec.Owner
+	}
+	if spec.Filename != "" {
+		annotations[AnnotationFilename] = spec.Filename
 	}
+	return annotations
+}
 
-	return &KubeClient{Clientset: clientset}, nil
+// JobSpec describes a single detection run for an uploaded file.
+//
+// With SourceURL set the pod downloads the upload from SourceURL and uploads
+// its results as a gzipped tar archive to ResultURL, so it needs no access to
+// the server's storage. Otherwise the pod mounts the PVC PvcName, and Source
+// and OutputDir are relative to the root of that volume. Either way the
+// detector names its outputs after the base name of Source. The pod runs the
+// image and command of Model with Settings.
+type JobSpec struct {
+	// JobID and Attempt name the run, see jobName.
+	JobID     string
+	Attempt   int
+	UploadID  string
+	Owner     string
+	BatchID   string
+	Filename  string
+	Model     *models.Model
+	Settings  models.Settings
+	SourceURL string
+	ResultURL string
+	Source    string
+	OutputDir string
+	PvcName   string
+	Namespace string
+	Timeout   time.Duration
 }
 
-func generatePodName(jobId string) string {
-	safeJobId := strings.ToLower(jobId)
-	safeJobId = strings.ReplaceAll(safeJobId, "_", "-")
-	var result strings.Builder
-	for _, r := range safeJobId {
-		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
-			result.WriteRune(r)
-		}
+// transferScript downloads the input, runs the command passed to the shell
+// and uploads the packed results.
+const transferScript = `set -e
+mkdir -p "$(dirname "$INPUT_PATH")"
+python3 -c 'import os, urllib.request; urllib.request.urlretrieve(os.environ["SOURCE_URL"], os.environ["INPUT_PATH"])'
+"$@"
+tar -C "$OUTPUT_DIR" -czf "$RESULT_ARCHIVE" .
+python3 -c 'import os, urllib.request; urllib.request.urlopen(urllib.request.Request(os.environ["RESULT_URL"], data=open(os.environ["RESULT_ARCHIVE"], "rb").read(), method="PUT", headers={"Content-Type": "application/gzip"}))'
+`
+
+func detectorPodSpec(spec JobSpec) (v1.PodSpec, error) {
+	container := v1.Container{
+		Name:  detectorContainerName,
+		Image: spec.Model.Image,
 	}
-	name := result.String()
-	if len(name) > 40 {
-		name = name[:40]
+	podSpec := v1.PodSpec{
+		RestartPolicy: v1.RestartPolicyNever,
+		SecurityContext: &v1.PodSecurityContext{
+			RunAsUser:  new(int64), // 0 = root
+			RunAsGroup: new(int64), // 0 = root
+		},
 	}
-	name = strings.Trim(name, "-")
-	if name == "" {
-		name = "default-job"
+
+	if spec.SourceURL != "" {
+		input := path.Join(workMountPath, "input", path.Base(spec.Source))
+		args, err := spec.Model.Args(models.Paths{Source: input, Project: workMountPath, Name: "detected"}, spec.Settings)
+		if err != nil {
+			return podSpec, err
+		}
+		container.Command = []string{"/bin/sh", "-c", transferScript, "sh"}
+		container.Args = args
+		container.Env = []v1.EnvVar{
+			{Name: "SOURCE_URL", Value: spec.SourceURL},
+			{Name: "RESULT_URL", Value: spec.ResultURL},
+			{Name: "INPUT_PATH", Value: input},
+			{Name: "OUTPUT_DIR", Value: path.Join(workMountPath, "detected")},
+			{Name: "RESULT_ARCHIVE", Value: path.Join(workMountPath, "results.tar.gz")},
+		}
+		container.VolumeMounts = []v1.VolumeMount{{Name: "work", MountPath: workMountPath}}
+		podSpec.Volumes = []v1.Volume{{
+			Name:         "work",
+			VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+		}}
+	} else {
+		args, err := spec.Model.Args(models.Paths{
+			Source:  path.Join(dataMountPath, spec.Source),
+			Project: path.Join(dataMountPath, path.Dir(spec.OutputDir)),
+			Name:    path.Base(spec.OutputDir),
+		}, spec.Settings)
+		if err != nil {
+			return podSpec, err
+		}
+		container.Command = args
+		container.VolumeMounts = []v1.VolumeMount{{Name: "image-storage", MountPath: dataMountPath}}
+		podSpec.Volumes = []v1.Volume{{
+			Name: "image-storage",
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: spec.PvcName,
+				},
+			},
+		}}
 	}
-	return fmt.Sprintf("yolo-job-%s-%d", name, time.Now().UnixNano()%10000)
+
+	podSpec.Containers = []v1.Container{container}
+	return podSpec, nil
 }
 
-func (kc *KubeClient) CreatePod(filename string, pvcName string, namespace string) (string, error) {
-	podName := generatePodName(filename)
-	pod := &v1.Pod{
+// NewDetectorJob returns the Job called name that runs the detection
+// described by spec.
+func NewDetectorJob(name string, spec JobSpec) (*batchv1.Job, error) {
+	podSpec, err := detectorPodSpec(spec)
+	if err != nil {
+		return nil, fmt.Errorf("rendering pod spec: %w", err)
+	}
+
+	var activeDeadline *int64
+	if spec.Timeout > 0 {
+		seconds := int64(spec.Timeout.Seconds())
+		activeDeadline = &seconds
+	}
+	backoffLimit := jobBackoffLimit
+
+	return &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      podName,
-			Namespace: namespace,
+			Name:        name,
+			Namespace:   spec.Namespace,
+			Labels:      jobLabels(spec),
+			Annotations: jobAnnotations(spec),
 		},
-		Spec: v1.PodSpec{
-			RestartPolicy: v1.RestartPolicyNever,
-			Volumes: []v1.Volume{
-				{
-					Name: "image-storage",
-					VolumeSource: v1.VolumeSource{
-						PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
-							ClaimName: pvcName,
-						},
-					},
-				},
-			},
-			Containers: []v1.Container{
-				{
-					Name:    "main-processor",
-					Image:   "docker.io/ultralytics/yolov5:latest",
-					Command: []string{"python3"},
-					Args: []string{
-						"detect.py",
-						"--source", "/mnt/data/" + filename,
-						"--project", "/mnt/data/",
-						"--name", filename + "-detected",
-						"--weights", "yolov5s.pt",
-					},
-					VolumeMounts: []v1.VolumeMount{
-						{
-							Name:      "image-storage",
-							MountPath: "/mnt/data",
-						},
-					},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: activeDeadline,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      jobLabels(spec),
+					Annotations: jobAnnotations(spec),
 				},
-			},
-			SecurityContext: &v1.PodSecurityContext{
-				RunAsUser:  new(int64), // 0 = root
-				RunAsGroup: new(int64), // 0 = root
+				Spec: podSpec,
 			},
 		},
+	}, nil
+}
+
+func (kc *KubeClient) CreateJob(spec JobSpec) (string, error) {
+	jobName := jobName(spec)
+	job, err := NewDetectorJob(jobName, spec)
+	if err != nil {
+		return "", err
 	}
 
-	log.Printf("Attempting to create pod: %s in namespace: %s for image: %s", podName, namespace, filename)
-	createdPod, err := kc.Clientset.CoreV1().Pods(namespace).Create(
+	log.Printf("Attempting to create job: %s in namespace: %s for image: %s", jobName, spec.Namespace, spec.Filename)
+	createdJob, err := kc.Clientset.BatchV1().Jobs(spec.Namespace).Create(
 		context.Background(),
-		pod,
+		job,
 		metav1.CreateOptions{},
 	)
+	if apierrors.IsAlreadyExists(err) {
+		log.Printf("Job %s already exists", jobName)
+		return jobName, nil
+	}
 	if err != nil {
-		log.Printf("Cannot create pod '%s': %v", podName, err)
+		log.Printf("Cannot create job '%s': %v", jobName, err)
 		return "", err
 	}
-	log.Printf("Successfully created pod: %s in namespace: %s", createdPod.Name, createdPod.Namespace)
-	return createdPod.Name, nil
+	log.Printf("Successfully created job: %s in namespace: %s", createdJob.Name, createdJob.Namespace)
+	return createdJob.Name, nil
+}
+
+// DeleteJob removes a job together with its pods. Deleting a job that no
+// longer exists is not an error.
+func (kc *KubeClient) DeleteJob(name string, namespace string) error {
+	propagation := metav1.DeletePropagationBackground
+	err := kc.Clientset.BatchV1().Jobs(namespace).Delete(
+		context.Background(),
+		name,
+		metav1.DeleteOptions{PropagationPolicy: &propagation},
+	)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("Cannot delete job '%s': %v", name, err)
+		return err
+	}
+	log.Printf("Deleted job: %s in namespace: %s", name, namespace)
+	return nil
 }
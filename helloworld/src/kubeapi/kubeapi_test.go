@@ -0,0 +1,131 @@
+package kubeapi
+
+import (
+	"context"
+	"testing"
+
+	"helloworld/models"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testModel(t *testing.T) *models.Model {
+	t.Helper()
+	registry, err := models.Builtin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	model, err := registry.Get("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return model
+}
+
+func testSpec(t *testing.T) JobSpec {
+	model := testModel(t)
+	settings, err := model.Resolve(models.Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return JobSpec{
+		JobID:     "0F8FAD5B-D9CB-469F-A165-70867728950E",
+		Attempt:   1,
+		UploadID:  "upload-1",
+		Owner:     "alice@example.com",
+		Filename:  "cat.jpg",
+		Model:     model,
+		Settings:  settings,
+		Source:    "uploads/cat.jpg",
+		OutputDir: "results/cat",
+		PvcName:   "detector-pvc",
+		Namespace: "detector",
+	}
+}
+
+func TestCreateJob(t *testing.T) {
+	clientset := fake.NewClientset()
+	kc := &KubeClient{Clientset: clientset}
+	spec := testSpec(t)
+
+	name, err := kc.CreateJob(spec)
+	if err != nil {
+		t.Fatalf("CreateJob: %v", err)
+	}
+	if want := "yolo-job-0f8fad5b-d9cb-469f-a165-70867728950e-1"; name != want {
+		t.Errorf("name = %q, want %q", name, want)
+	}
+
+	job, err := clientset.BatchV1().Jobs("detector").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("getting created job: %v", err)
+	}
+	if job.Labels[LabelApp] != AppName || job.Labels[LabelUploadID] != "upload-1" {
+		t.Errorf("labels = %v", job.Labels)
+	}
+	if _, ok := job.Labels[LabelOwner]; ok {
+		t.Errorf("owner %q is not a valid label value but was set as one", spec.Owner)
+	}
+	if job.Annotations[AnnotationOwner] != spec.Owner || job.Annotations[AnnotationFilename] != spec.Filename {
+		t.Errorf("annotations = %v", job.Annotations)
+	}
+	if job.Spec.Template.Labels[LabelUploadID] != "upload-1" {
+		t.Errorf("pod template labels = %v", job.Spec.Template.Labels)
+	}
+	if *job.Spec.BackoffLimit != 0 {
+		t.Errorf("backoff limit = %d, want 0", *job.Spec.BackoffLimit)
+	}
+	volumes := job.Spec.Template.Spec.Volumes
+	if len(volumes) != 1 || volumes[0].PersistentVolumeClaim == nil || volumes[0].PersistentVolumeClaim.ClaimName != "detector-pvc" {
+		t.Errorf("volumes = %+v", volumes)
+	}
+
+	// Creating the same attempt again finds the existing Job.
+	again, err := kc.CreateJob(spec)
+	if err != nil || again != name {
+		t.Errorf("second CreateJob = %q, %v; want %q, nil", again, err, name)
+	}
+	list, err := clientset.BatchV1().Jobs("detector").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 {
+		t.Errorf("%d jobs exist, want 1", len(list.Items))
+	}
+}
+
+func TestCreateJobPresigned(t *testing.T) {
+	clientset := fake.NewClientset()
+	kc := &KubeClient{Clientset: clientset}
+	spec := testSpec(t)
+	spec.SourceURL = "https://storage.example.com/uploads/cat.jpg?sig=1"
+	spec.ResultURL = "https://storage.example.com/results/cat.tar.gz?sig=2"
+
+	name, err := kc.CreateJob(spec)
+	if err != nil {
+		t.Fatalf("CreateJob: %v", err)
+	}
+	job, err := clientset.BatchV1().Jobs("detector").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := job.Spec.Template.Spec
+	if pod.Volumes[0].EmptyDir == nil {
+		t.Errorf("presigned jobs must not mount the PVC, volumes = %+v", pod.Volumes)
+	}
+	env := map[string]string{}
+	for _, e := range pod.Containers[0].Env {
+		env[e.Name] = e.Value
+	}
+	if env["SOURCE_URL"] != spec.SourceURL || env["RESULT_URL"] != spec.ResultURL {
+		t.Errorf("env = %v", env)
+	}
+}
+
+func TestDeleteJobMissing(t *testing.T) {
+	kc := &KubeClient{Clientset: fake.NewClientset()}
+	if err := kc.DeleteJob("missing", "detector"); err != nil {
+		t.Errorf("deleting a missing job: %v", err)
+	}
+}
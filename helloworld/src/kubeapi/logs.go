@@ -0,0 +1,26 @@
+package kubeapi
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// ErrPodNotFound is returned for pods that no longer exist.
+var ErrPodNotFound = errors.New("pod not found")
+
+// StreamLogs returns the log of the detector container of podName. With
+// follow set the stream stays open until the container exits or ctx is done.
+func (kc *KubeClient) StreamLogs(ctx context.Context, namespace, podName string, follow bool) (io.ReadCloser, error) {
+	rc, err := kc.Clientset.CoreV1().Pods(namespace).GetLogs(podName, &v1.PodLogOptions{
+		Container: detectorContainerName,
+		Follow:    follow,
+	}).Stream(ctx)
+	if apierrors.IsNotFound(err) {
+		return nil, ErrPodNotFound
+	}
+	return rc, err
+}
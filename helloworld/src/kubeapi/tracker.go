@@ -0,0 +1,285 @@
+package kubeapi
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+type JobState string
+
+const (
+	// JobQueued jobs wait in the server's queue and have no cluster Job yet.
+	JobQueued    JobState = "Queued"
+	JobPending   JobState = "Pending"
+	JobRunning   JobState = "Running"
+	JobSucceeded JobState = "Succeeded"
+	JobFailed    JobState = "Failed"
+	JobTimedOut  JobState = "TimedOut"
+	JobCancelled JobState = "Cancelled"
+)
+
+// Terminal reports whether no further transitions are expected.
+func (s JobState) Terminal() bool {
+	return s == JobSucceeded || s == JobFailed || s == JobTimedOut || s == JobCancelled
+}
+
+// JobStatus is the observed state of the detection job belonging to one upload.
+type JobStatus struct {
+	// Cluster names the cluster the Job runs in.
+	Cluster    string
+	UploadID   string
+	JobName    string
+	PodName    string
+	State      JobState
+	StartedAt  time.Time
+	FinishedAt time.Time
+	ExitCode   *int32
+	Message    string
+	// Reason classifies why a failed job failed, see the Reason constants.
+	Reason string
+	// Transient is set on failures that may not happen again when the
+	// detection is retried unchanged.
+	Transient bool
+	// Blocked is set on failures of pods that cannot start. Their Job keeps
+	// running until it is deleted.
+	Blocked bool
+}
+
+// JobTracker watches detection Jobs and their pods through shared informers
+// and keeps the latest JobStatus for every upload.
+type JobTracker struct {
+	cluster   string
+	factory   informers.SharedInformerFactory
+	jobLister batchlisters.JobLister
+	podLister corelisters.PodLister
+	synced    []cache.InformerSynced
+
+	mu       sync.RWMutex
+	statuses map[string]JobStatus
+	handlers []func(JobStatus)
+}
+
+// NewJobTracker watches the detection Jobs in namespace of the cluster called
+// cluster.
+func NewJobTracker(cluster string, clientset kubernetes.Interface, namespace string, resync time.Duration) *JobTracker {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, resync,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = detectorSelector
+		}),
+	)
+
+	jobInformer := factory.Batch().V1().Jobs().Informer()
+	podInformer := factory.Core().V1().Pods().Informer()
+	t := &JobTracker{
+		cluster:   cluster,
+		factory:   factory,
+		jobLister: factory.Batch().V1().Jobs().Lister(),
+		podLister: factory.Core().V1().Pods().Lister(),
+		synced:    []cache.InformerSynced{jobInformer.HasSynced, podInformer.HasSynced},
+		statuses:  make(map[string]JobStatus),
+	}
+
+	jobInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { t.onJob(obj) },
+		UpdateFunc: func(_, obj interface{}) { t.onJob(obj) },
+		DeleteFunc: t.onJobDeleted,
+	})
+	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { t.onPod(obj) },
+		UpdateFunc: func(_, obj interface{}) { t.onPod(obj) },
+	})
+
+	return t
+}
+
+// OnChange registers fn to be called whenever the state of a job changes.
+// Handlers must be registered before Run.
+func (t *JobTracker) OnChange(fn func(JobStatus)) {
+	t.handlers = append(t.handlers, fn)
+}
+
+// Run starts the informers and blocks until their caches are synced.
+func (t *JobTracker) Run(ctx context.Context) error {
+	t.factory.Start(ctx.Done())
+	for typ, ok := range t.factory.WaitForCacheSync(ctx.Done()) {
+		if !ok {
+			return fmt.Errorf("failed to sync informer cache for %v", typ)
+		}
+	}
+	log.Printf("Job tracker for cluster %s started.", t.cluster)
+	return nil
+}
+
+// HasSynced reports whether the caches reflect the cluster, i.e. whether the
+// cluster was reachable since the tracker started.
+func (t *JobTracker) HasSynced() bool {
+	for _, synced := range t.synced {
+		if !synced() {
+			return false
+		}
+	}
+	return true
+}
+
+// Status returns the last observed status of the job belonging to uploadID.
+func (t *JobTracker) Status(uploadID string) (JobStatus, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	status, ok := t.statuses[uploadID]
+	return status, ok
+}
+
+func (t *JobTracker) onJob(obj interface{}) {
+	job, ok := obj.(*batchv1.Job)
+	if !ok {
+		return
+	}
+	t.update(job)
+}
+
+func (t *JobTracker) onPod(obj interface{}) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return
+	}
+	uploadID := pod.Labels[LabelUploadID]
+	if uploadID == "" {
+		return
+	}
+	jobs, err := t.jobLister.Jobs(pod.Namespace).List(labels.SelectorFromSet(labels.Set{LabelUploadID: uploadID}))
+	if err != nil {
+		log.Printf("Failed to list jobs for upload %s: %v", uploadID, err)
+		return
+	}
+	for _, job := range jobs {
+		t.update(job)
+	}
+}
+
+func (t *JobTracker) onJobDeleted(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	job, ok := obj.(*batchv1.Job)
+	if !ok {
+		return
+	}
+	uploadID := job.Labels[LabelUploadID]
+	t.mu.Lock()
+	if status, ok := t.statuses[uploadID]; ok && status.JobName == job.Name {
+		delete(t.statuses, uploadID)
+	}
+	t.mu.Unlock()
+}
+
+func (t *JobTracker) update(job *batchv1.Job) {
+	uploadID := job.Labels[LabelUploadID]
+	if uploadID == "" {
+		return
+	}
+
+	pods, err := t.podLister.Pods(job.Namespace).List(labels.SelectorFromSet(labels.Set{LabelUploadID: uploadID}))
+	if err != nil {
+		log.Printf("Failed to list pods for job %s: %v", job.Name, err)
+		return
+	}
+	status := ComputeStatus(job, pods)
+	status.Cluster = t.cluster
+
+	t.mu.Lock()
+	previous, seen := t.statuses[uploadID]
+	t.statuses[uploadID] = status
+	t.mu.Unlock()
+
+	if seen && previous.State == status.State && previous.PodName == status.PodName && previous.Reason == status.Reason {
+		return
+	}
+	for _, fn := range t.handlers {
+		fn(status)
+	}
+}
+
+// ComputeStatus derives the status of a detection from its Job and the pods
+// the Job created.
+func ComputeStatus(job *batchv1.Job, pods []*v1.Pod) JobStatus {
+	status := JobStatus{
+		UploadID: job.Labels[LabelUploadID],
+		JobName:  job.Name,
+		State:    JobPending,
+	}
+	if job.Status.StartTime != nil {
+		status.StartedAt = job.Status.StartTime.Time
+	}
+
+	var latest *v1.Pod
+	for _, pod := range pods {
+		if latest == nil || pod.CreationTimestamp.After(latest.CreationTimestamp.Time) {
+			latest = pod
+		}
+	}
+	if latest != nil {
+		status.PodName = latest.Name
+		status.ExitCode = containerExitCode(latest)
+		if latest.Status.Phase == v1.PodRunning {
+			status.State = JobRunning
+		}
+		if f, ok := blockedFailure(latest); ok {
+			status.State = JobFailed
+			status.Reason = f.reason
+			status.Message = f.message
+			status.Transient = f.transient
+			status.Blocked = true
+		}
+	}
+
+	for _, cond := range job.Status.Conditions {
+		if cond.Status != v1.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case batchv1.JobComplete:
+			status.State = JobSucceeded
+		case batchv1.JobFailed:
+			status.State = JobFailed
+			if cond.Reason == batchv1.JobReasonDeadlineExceeded {
+				status.State = JobTimedOut
+			}
+			f := classifyFailure(cond, latest)
+			status.Reason = f.reason
+			status.Message = f.message
+			status.Transient = f.transient
+			status.Blocked = false
+		default:
+			continue
+		}
+		status.FinishedAt = cond.LastTransitionTime.Time
+		if job.Status.CompletionTime != nil {
+			status.FinishedAt = job.Status.CompletionTime.Time
+		}
+	}
+	return status
+}
+
+func containerExitCode(pod *v1.Pod) *int32 {
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name == detectorContainerName && cs.State.Terminated != nil {
+			code := cs.State.Terminated.ExitCode
+			return &code
+		}
+	}
+	return nil
+}
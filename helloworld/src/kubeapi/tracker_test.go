@@ -0,0 +1,175 @@
+package kubeapi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+const testNamespace = "detector"
+
+// startTracker runs a tracker on a fake clientset and returns the clientset
+// and the statuses the tracker reports. It returns once the informers watch,
+// so no object created afterwards is missed.
+func startTracker(t *testing.T) (*fake.Clientset, <-chan JobStatus) {
+	t.Helper()
+	clientset := fake.NewClientset()
+	watching := make(chan struct{}, 2)
+	clientset.PrependWatchReactor("*", func(action clienttesting.Action) (bool, watch.Interface, error) {
+		w, err := clientset.Tracker().Watch(action.GetResource(), action.GetNamespace())
+		if err != nil {
+			return false, nil, err
+		}
+		watching <- struct{}{}
+		return true, w, nil
+	})
+
+	tracker := NewJobTracker("test", clientset, testNamespace, 0)
+	statuses := make(chan JobStatus, 16)
+	tracker.OnChange(func(s JobStatus) { statuses <- s })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := tracker.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-watching:
+		case <-time.After(5 * time.Second):
+			t.Fatal("informers did not start watching")
+		}
+	}
+	return clientset, statuses
+}
+
+func expectState(t *testing.T, statuses <-chan JobStatus, want JobState) JobStatus {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case s := <-statuses:
+			if s.State == want {
+				return s
+			}
+		case <-timeout:
+			t.Fatalf("no %s status reported", want)
+		}
+	}
+}
+
+func testJob(uploadID string) *batchv1.Job {
+	labels := map[string]string{LabelApp: AppName, LabelUploadID: uploadID}
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "yolo-job-" + uploadID, Namespace: testNamespace, Labels: labels},
+	}
+}
+
+func testPod(uploadID string, phase v1.PodPhase) *v1.Pod {
+	labels := map[string]string{LabelApp: AppName, LabelUploadID: uploadID}
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "yolo-job-" + uploadID + "-abcde", Namespace: testNamespace, Labels: labels},
+		Status:     v1.PodStatus{Phase: phase},
+	}
+}
+
+func finish(job *batchv1.Job, condition batchv1.JobConditionType, reason string) {
+	now := metav1.Now()
+	job.Status.Conditions = append(job.Status.Conditions, batchv1.JobCondition{
+		Type:               condition,
+		Status:             v1.ConditionTrue,
+		Reason:             reason,
+		LastTransitionTime: now,
+	})
+}
+
+func TestTrackerSucceeded(t *testing.T) {
+	clientset, statuses := startTracker(t)
+	ctx := context.Background()
+
+	job, err := clientset.BatchV1().Jobs(testNamespace).Create(ctx, testJob("ok"), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectState(t, statuses, JobPending)
+
+	pod, err := clientset.CoreV1().Pods(testNamespace).Create(ctx, testPod("ok", v1.PodRunning), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := expectState(t, statuses, JobRunning); s.PodName != pod.Name || s.Cluster != "test" {
+		t.Errorf("running status = %+v", s)
+	}
+
+	finish(job, batchv1.JobComplete, "")
+	if _, err := clientset.BatchV1().Jobs(testNamespace).UpdateStatus(ctx, job, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if s := expectState(t, statuses, JobSucceeded); s.UploadID != "ok" || s.FinishedAt.IsZero() {
+		t.Errorf("succeeded status = %+v", s)
+	}
+}
+
+func TestTrackerFailed(t *testing.T) {
+	clientset, statuses := startTracker(t)
+	ctx := context.Background()
+
+	job, err := clientset.BatchV1().Jobs(testNamespace).Create(ctx, testJob("bad"), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectState(t, statuses, JobPending)
+
+	pod := testPod("bad", v1.PodRunning)
+	pod, err = clientset.CoreV1().Pods(testNamespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectState(t, statuses, JobRunning)
+
+	pod.Status.Phase = v1.PodFailed
+	pod.Status.ContainerStatuses = []v1.ContainerStatus{{
+		Name:  detectorContainerName,
+		State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 2}},
+	}}
+	if _, err := clientset.CoreV1().Pods(testNamespace).UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	expectState(t, statuses, JobPending)
+
+	finish(job, batchv1.JobFailed, batchv1.JobReasonBackoffLimitExceeded)
+	if _, err := clientset.BatchV1().Jobs(testNamespace).UpdateStatus(ctx, job, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := expectState(t, statuses, JobFailed)
+	if s.Reason != ReasonExitCode || s.Transient || s.ExitCode == nil || *s.ExitCode != 2 {
+		t.Errorf("failed status = %+v", s)
+	}
+}
+
+func TestTrackerTimedOut(t *testing.T) {
+	clientset, statuses := startTracker(t)
+	ctx := context.Background()
+
+	job, err := clientset.BatchV1().Jobs(testNamespace).Create(ctx, testJob("slow"), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectState(t, statuses, JobPending)
+
+	finish(job, batchv1.JobFailed, batchv1.JobReasonDeadlineExceeded)
+	if _, err := clientset.BatchV1().Jobs(testNamespace).UpdateStatus(ctx, job, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if s := expectState(t, statuses, JobTimedOut); s.Reason != ReasonDeadline {
+		t.Errorf("timed out status = %+v", s)
+	}
+}
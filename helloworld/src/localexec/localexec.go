@@ -0,0 +1,238 @@
+// Package localexec runs detection jobs as subprocesses of the server, so
+// that detection works in development and CI without a Kubernetes cluster.
+package localexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"helloworld/kubeapi"
+	"helloworld/models"
+)
+
+// retention is how long finished jobs are kept for their logs.
+const retention = time.Hour
+
+// Executor runs the detector command of every job in a local process. It
+// implements both kubeapi.Executor and kubeapi.Tracker.
+type Executor struct {
+	cluster string
+	dataDir string
+	stub    bool
+
+	mu       sync.Mutex
+	jobs     map[string]*job
+	handlers []func(kubeapi.JobStatus)
+}
+
+type job struct {
+	cancel context.CancelFunc
+	logs   *logBuffer
+}
+
+// New returns an executor reporting its jobs as running in cluster. Jobs
+// without presigned URLs read and write the storage directory dataDir. With
+// stub set the detector is replaced by one writing fake results, see
+// runStub.
+func New(cluster, dataDir string, stub bool) *Executor {
+	return &Executor{cluster: cluster, dataDir: dataDir, stub: stub, jobs: map[string]*job{}}
+}
+
+// OnChange registers fn to be called whenever the state of a job changes.
+func (e *Executor) OnChange(fn func(kubeapi.JobStatus)) {
+	e.handlers = append(e.handlers, fn)
+}
+
+// Run does nothing; local jobs are known from the start.
+func (e *Executor) Run(ctx context.Context) error {
+	return nil
+}
+
+// HasSynced always reports true.
+func (e *Executor) HasSynced() bool {
+	return true
+}
+
+// CreateJob starts the detection described by spec in the background.
+func (e *Executor) CreateJob(spec kubeapi.JobSpec) (string, error) {
+	if spec.SourceURL == "" && e.dataDir == "" {
+		return "", errors.New("local executor needs presigned URLs or a storage directory")
+	}
+
+	// Like Kubernetes Jobs, every attempt of a detection job has its own
+	// name, and starting an attempt twice finds the first run.
+	name := fmt.Sprintf("local-%s-%d", spec.JobID, spec.Attempt)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.jobs[name]; ok {
+		return name, nil
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if spec.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), spec.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	j := &job{cancel: cancel, logs: newLogBuffer()}
+	e.jobs[name] = j
+
+	status := kubeapi.JobStatus{
+		Cluster:  e.cluster,
+		UploadID: spec.UploadID,
+		JobName:  name,
+		PodName:  name,
+		State:    kubeapi.JobPending,
+	}
+	go e.run(ctx, j, spec, status)
+	return name, nil
+}
+
+// DeleteJob stops the job called name and forgets it.
+func (e *Executor) DeleteJob(name string, namespace string) error {
+	e.mu.Lock()
+	j, ok := e.jobs[name]
+	delete(e.jobs, name)
+	e.mu.Unlock()
+	if ok {
+		j.cancel()
+		log.Printf("Deleted local job: %s", name)
+	}
+	return nil
+}
+
+// Has reports whether the job called name was started by this executor and
+// is still kept. Jobs are forgotten when the server stops.
+func (e *Executor) Has(name string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.jobs[name]
+	return ok
+}
+
+// StreamLogs returns the output of the job called podName.
+func (e *Executor) StreamLogs(ctx context.Context, namespace, podName string, follow bool) (io.ReadCloser, error) {
+	e.mu.Lock()
+	j, ok := e.jobs[podName]
+	e.mu.Unlock()
+	if !ok {
+		return nil, kubeapi.ErrPodNotFound
+	}
+	return j.logs.reader(ctx, follow), nil
+}
+
+func (e *Executor) notify(status kubeapi.JobStatus) {
+	for _, fn := range e.handlers {
+		fn(status)
+	}
+}
+
+// run executes one job and reports its progress. Jobs that were deleted
+// report nothing once their process is stopped.
+func (e *Executor) run(ctx context.Context, j *job, spec kubeapi.JobSpec, status kubeapi.JobStatus) {
+	defer j.cancel()
+	e.notify(status)
+
+	status.State = kubeapi.JobRunning
+	status.StartedAt = time.Now()
+	e.notify(status)
+
+	err := e.execute(ctx, j.logs, spec)
+	j.logs.close()
+
+	e.mu.Lock()
+	_, alive := e.jobs[status.JobName]
+	e.mu.Unlock()
+	if !alive {
+		return
+	}
+
+	status.FinishedAt = time.Now()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		status.State = kubeapi.JobSucceeded
+		status.ExitCode = new(int32)
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		status.State = kubeapi.JobTimedOut
+		status.Reason = kubeapi.ReasonDeadline
+		status.Message = "detector did not finish in time"
+	case errors.As(err, &exitErr):
+		code := int32(exitErr.ExitCode())
+		status.State = kubeapi.JobFailed
+		status.ExitCode = &code
+		status.Reason = kubeapi.ReasonExitCode
+		status.Message = fmt.Sprintf("detector exited with code %d", code)
+	default:
+		status.State = kubeapi.JobFailed
+		status.Reason = kubeapi.ReasonUnknown
+		status.Message = err.Error()
+	}
+	e.notify(status)
+
+	time.AfterFunc(retention, func() {
+		e.mu.Lock()
+		if e.jobs[status.JobName] == j {
+			delete(e.jobs, status.JobName)
+		}
+		e.mu.Unlock()
+	})
+}
+
+// execute runs the detector for spec, transferring data through the
+// presigned URLs of spec when it has them.
+func (e *Executor) execute(ctx context.Context, out io.Writer, spec kubeapi.JobSpec) error {
+	if spec.SourceURL == "" {
+		return e.detect(ctx, out, spec, models.Paths{
+			Source:  filepath.Join(e.dataDir, filepath.FromSlash(spec.Source)),
+			Project: filepath.Join(e.dataDir, filepath.FromSlash(path.Dir(spec.OutputDir))),
+			Name:    path.Base(spec.OutputDir),
+		})
+	}
+
+	work, err := os.MkdirTemp("", "detector-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+
+	input := filepath.Join(work, "input", path.Base(spec.Source))
+	if err := download(ctx, spec.SourceURL, input); err != nil {
+		return fmt.Errorf("downloading input: %w", err)
+	}
+	paths := models.Paths{Source: input, Project: work, Name: "detected"}
+	if err := e.detect(ctx, out, spec, paths); err != nil {
+		return err
+	}
+	if err := uploadResults(ctx, spec.ResultURL, filepath.Join(work, "detected")); err != nil {
+		return fmt.Errorf("uploading results: %w", err)
+	}
+	return nil
+}
+
+// detect runs the model's command, or the stub, on paths.
+func (e *Executor) detect(ctx context.Context, out io.Writer, spec kubeapi.JobSpec, paths models.Paths) error {
+	if e.stub {
+		return runStub(out, paths, spec.Settings)
+	}
+	args, err := spec.Model.Args(paths, spec.Settings)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "+ %s\n", strings.Join(args, " "))
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	return cmd.Run()
+}
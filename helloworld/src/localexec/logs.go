@@ -0,0 +1,79 @@
+package localexec
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// logBuffer keeps the output of a job and lets readers follow it.
+type logBuffer struct {
+	mu      sync.Mutex
+	data    []byte
+	done    bool
+	changed chan struct{}
+}
+
+func newLogBuffer() *logBuffer {
+	return &logBuffer{changed: make(chan struct{})}
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data = append(b.data, p...)
+	b.signal()
+	return len(p), nil
+}
+
+// close marks the output complete.
+func (b *logBuffer) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done = true
+	b.signal()
+}
+
+func (b *logBuffer) signal() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *logBuffer) reader(ctx context.Context, follow bool) io.ReadCloser {
+	return &logReader{ctx: ctx, b: b, follow: follow}
+}
+
+// logReader reads a logBuffer from the start. Following readers wait for
+// more output until the job finishes or ctx is done.
+type logReader struct {
+	ctx    context.Context
+	b      *logBuffer
+	off    int
+	follow bool
+}
+
+func (r *logReader) Read(p []byte) (int, error) {
+	for {
+		r.b.mu.Lock()
+		if r.off < len(r.b.data) {
+			n := copy(p, r.b.data[r.off:])
+			r.off += n
+			r.b.mu.Unlock()
+			return n, nil
+		}
+		done, changed := r.b.done, r.b.changed
+		r.b.mu.Unlock()
+		if done || !r.follow {
+			return 0, io.EOF
+		}
+		select {
+		case <-r.ctx.Done():
+			return 0, r.ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (r *logReader) Close() error {
+	return nil
+}
@@ -0,0 +1,60 @@
+package localexec
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"helloworld/models"
+)
+
+// stubClasses is the number of COCO classes the stub picks from.
+const stubClasses = 80
+
+// runStub stands in for detect.py. It copies the input to the output
+// directory as the "annotated" image and writes a label file with up to
+// four detections derived from the input's hash, so the same input always
+// yields the same results.
+func runStub(out io.Writer, paths models.Paths, settings models.Settings) error {
+	data, err := os.ReadFile(paths.Source)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(paths.Project, paths.Name)
+	if err := os.MkdirAll(filepath.Join(dir, "labels"), 0o755); err != nil {
+		return err
+	}
+	base := filepath.Base(paths.Source)
+	if err := os.WriteFile(filepath.Join(dir, base), data, 0o644); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+	n := int(sum[0]%4) + 1
+	var labels strings.Builder
+	for i := range n {
+		b := sum[1+i*6:]
+		w := 0.1 + 0.4*unit(b[0])
+		h := 0.1 + 0.4*unit(b[1])
+		x := w/2 + (1-w)*unit(b[2])
+		y := h/2 + (1-h)*unit(b[3])
+		conf := settings.Conf + (1-settings.Conf)*unit(b[4])
+		class := int(binary.BigEndian.Uint16(sum[26+i:])) % stubClasses
+		fmt.Fprintf(&labels, "%d %.6f %.6f %.6f %.6f %.6f\n", class, x, y, w, h, conf)
+	}
+	stem := strings.TrimSuffix(base, filepath.Ext(base))
+	if err := os.WriteFile(filepath.Join(dir, "labels", stem+".txt"), []byte(labels.String()), 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "stub detector: %s: %d detections, results saved to %s\n", base, n, dir)
+	return nil
+}
+
+// unit maps b to [0, 1].
+func unit(b byte) float64 {
+	return float64(b) / 255
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	auth "helloworld/db"
+	"helloworld/kubeapi"
+	"helloworld/storage"
+
+	"github.com/gorilla/websocket"
+)
+
+// logArchiveTimeout bounds how long archiving the log of a finished pod may
+// take.
+const logArchiveTimeout = 30 * time.Second
+
+// logKey is where the log of podName, run for job jobID, is archived.
+func logKey(jobID, podName string) string {
+	return path.Join("logs", jobID, podName+".log")
+}
+
+// archiveLogs copies the log of the detector pod podName of job into storage
+// so that it stays readable after the pod is deleted.
+func (a *App) archiveLogs(job *auth.Job, podName string) {
+	if podName == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), logArchiveTimeout)
+	defer cancel()
+
+	cluster, err := a.jobCluster(job)
+	if err != nil {
+		log.Printf("Failed to read log of pod %s: %v", podName, err)
+		return
+	}
+	rc, err := cluster.Executor.StreamLogs(ctx, cluster.Namespace, podName, false)
+	if err != nil {
+		log.Printf("Failed to read log of pod %s: %v", podName, err)
+		return
+	}
+	defer rc.Close()
+	if err := a.Storage.Put(ctx, logKey(job.ID, podName), rc, -1, "text/plain; charset=utf-8"); err != nil {
+		log.Printf("Failed to archive log of pod %s: %v", podName, err)
+	}
+}
+
+// openJobLog returns the log of the latest pod of job, live from the cluster
+// while the pod exists and from the archive afterwards or while the cluster
+// cannot be reached.
+func (a *App) openJobLog(ctx context.Context, job *auth.Job, follow bool) (io.ReadCloser, error) {
+	cluster, err := a.jobCluster(job)
+	if err == nil {
+		var rc io.ReadCloser
+		rc, err = cluster.Executor.StreamLogs(ctx, cluster.Namespace, job.PodName, follow)
+		if err == nil {
+			return rc, nil
+		}
+	}
+	rc, _, archiveErr := a.Storage.Get(ctx, logKey(job.ID, job.PodName))
+	if errors.Is(archiveErr, storage.ErrNotFound) && !errors.Is(err, kubeapi.ErrPodNotFound) {
+		return nil, err
+	}
+	return rc, archiveErr
+}
+
+// @Summary Job Logs
+// @Description Streams the log of the detector pod of a job. Finished pods are served from the log archive. WebSocket requests receive one text message per log line.
+// @Produce plain
+// @Param id path string true "Job ID"
+// @Param follow query bool false "Keep streaming until the pod exits"
+// @Success 200 {string} string "The log"
+// @Failure 400 {string} string "Bad request"
+// @Failure 404 {string} string "Job or log not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /api/v1/jobs/{id}/logs [get]
+func (a *App) jobLogs(w http.ResponseWriter, r *http.Request) {
+	job, ok := lookupJob(w, r)
+	if !ok {
+		return
+	}
+	follow := false
+	if v := r.URL.Query().Get("follow"); v != "" {
+		var err error
+		if follow, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "follow must be a boolean", http.StatusBadRequest)
+			return
+		}
+	}
+	if job.PodName == "" {
+		http.Error(w, "Log not found", http.StatusNotFound)
+		return
+	}
+
+	// The stream gets its own context, since the request context of a
+	// WebSocket is not cancelled when the client goes away.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	rc, err := a.openJobLog(ctx, job, follow)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "Log not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to open log of job %s: %v", job.ID, err)
+		http.Error(w, "Unable to read log", http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	if websocket.IsWebSocketUpgrade(r) {
+		streamLogLines(w, r, rc, cancel)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := rc.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// streamLogLines sends every line of rc as a text message over a WebSocket
+// until rc ends or the client goes away. cancel stops the stream rc reads.
+func streamLogLines(w http.ResponseWriter, r *http.Request, rc io.ReadCloser, cancel context.CancelFunc) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer conn.Close()
+
+	// Reading detects the client closing the connection; stopping the
+	// stream then ends the scan below. Closing rc alone does not stop every
+	// stream, e.g. the followed logs of the local executor.
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				cancel()
+				rc.Close()
+				return
+			}
+		}
+	}()
+
+	scanner := bufio.NewScanner(rc)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		if err := conn.WriteMessage(websocket.TextMessage, scanner.Bytes()); err != nil {
+			return
+		}
+	}
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
@@ -6,71 +6,193 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"html"
+	"html/template"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
-	"text/template"
 	"time"
 
 	auth "helloworld/db"
 	_ "helloworld/docs"
+	"helloworld/events"
+	"helloworld/kafka"
 	"helloworld/kubeapi"
+	"helloworld/models"
+	"helloworld/storage"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	httpSwagger "github.com/swaggo/http-swagger"
 )
 
 type App struct {
-	KubeClient             *kubeapi.KubeClient
-	PvcName                string
-	Namespace              string
+	Clusters *kubeapi.Pool
+	Storage  storage.Backend
+	Models   *models.Registry
+	Queue    *jobQueue
+	Retry    retryPolicy
+	// Events carries notifications between replicas, nil when Kafka is
+	// disabled.
+	Events                 *kafka.MyKafka
 	UploadDir              string
 	PodCompletionTimeout   time.Duration
-	WsConnections          map[*websocket.Conn]bool // WebSocket kapcsolatok
+	WsConnections          map[*websocket.Conn]string // WebSocket kapcsolatok és a hozzájuk tartozó felhasználók
 	WsMutex                *sync.Mutex
 	UploadNotificationChan chan string
 }
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
+	CheckOrigin: sameOrigin,
+}
+
+// sameOrigin accepts WebSocket connections from pages served by this server,
+// so that other sites cannot use the cookies of a logged-in user. Clients
+// other than browsers send no Origin and are accepted.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
 		return true
-	},
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
 }
 
-func main() {
-	auth.InitDB()
+// kubeClientOptions reads the -kubeconfig and -kube-context flags and the
+// KUBECONFIG_SECRET* environment variables.
+func kubeClientOptions() kubeapi.ClientOptions {
+	opts := kubeapi.ClientOptions{
+		SecretName:      os.Getenv("KUBECONFIG_SECRET"),
+		SecretNamespace: getenv("KUBECONFIG_SECRET_NAMESPACE", "detector"),
+		SecretKey:       getenv("KUBECONFIG_SECRET_KEY", "config"),
+	}
+	flag.StringVar(&opts.Kubeconfig, "kubeconfig", "", "path to the kubeconfig file to use instead of KUBECONFIG or the in-cluster configuration")
+	flag.StringVar(&opts.Context, "kube-context", getenv("KUBE_CONTEXT", ""), "kubeconfig context to use")
+	flag.Parse()
+	return opts
+}
 
-	kc, err := kubeapi.NewKubeClient()
-	if err != nil {
-		log.Fatalf("Failed to initialize KubeClient: %v", err)
+func main() {
+	controllerMode := flag.Bool("controller", false, "reconcile DetectionJob objects instead of serving the web interface")
+	replayMode := flag.Bool("replay-dlq", false, "move the events of the Kafka dead-letter topic back to their topics and exit")
+	kubeOpts := kubeClientOptions()
+	if *controllerMode {
+		runController(kubeOpts)
+		return
 	}
+	if *replayMode {
+		runReplay()
+		return
+	}
+	if err := auth.SetJWTKey([]byte(os.Getenv("JWT_SIGNING_KEY"))); err != nil {
+		log.Fatalf("Failed to configure JWT_SIGNING_KEY: %v", err)
+	}
+	auth.InitDB()
 
 	app := &App{
-		KubeClient:             kc,
-		PvcName:                "detector-pvc",
-		Namespace:              "detector",
 		UploadDir:              "/mnt/data/",
-		WsConnections:          make(map[*websocket.Conn]bool),
+		PodCompletionTimeout:   10 * time.Minute,
+		WsConnections:          make(map[*websocket.Conn]string),
 		WsMutex:                &sync.Mutex{},
 		UploadNotificationChan: make(chan string),
 	}
 
+	store, local, err := newStorage(context.Background(), app.UploadDir)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	app.Storage = store
+
+	var dataDir string
+	if local != nil {
+		dataDir = local.Root
+	}
+	app.Clusters, err = newClusterPool(kubeOpts, dataDir)
+	if err != nil {
+		log.Fatalf("Failed to initialize clusters: %v", err)
+	}
+
+	app.Models, err = newModelRegistry()
+	if err != nil {
+		log.Fatalf("Failed to load detection models: %v", err)
+	}
+	if local != nil {
+		http.Handle("/storage/", http.StripPrefix("/storage", local))
+	}
+
 	go app.listenForUploadNotifications()
 
-	http.HandleFunc("/", app.uploadFile)
-	http.HandleFunc("/lists", listFiles)
-	http.HandleFunc("/lists/", app.displayImage)
-	http.HandleFunc("/files/", serveFile)
+	app.Events, err = newEventBus(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to configure Kafka: %v", err)
+	}
+	if app.Events != nil {
+		go app.Events.ConsumeMessages(context.Background(), app.messageHandler)
+		go app.Events.RelayOutbox(context.Background(), outboxStore{})
+	}
+
+	limits, err := queueLimitsFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure job queue: %v", err)
+	}
+	app.Queue = newJobQueue(limits)
+	app.Retry, err = retryPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure job retries: %v", err)
+	}
+	go app.runQueue(context.Background())
+
+	gc, err := gcConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure garbage collection: %v", err)
+	}
+	go app.runGC(context.Background(), gc)
+
+	app.runTrackers(context.Background())
+
+	http.HandleFunc("/", app.index)
+	http.Handle("/lists", auth.RequireAuthFunc(listFiles))
+	http.Handle("/lists/", auth.RequireAuthFunc(app.displayImage))
+	http.Handle("/files/", auth.RequireAuthFunc(app.serveFile))
+	auth.OnRegister = func(username string) []auth.OutboxMessage {
+		return app.outbox(&events.UserRegistered{Username: username})
+	}
+	auth.OnRegistered = func(username string) {
+		app.announce(&events.UserRegistered{Username: username})
+	}
 	http.HandleFunc("/register", auth.RegisterHandler)
 	http.HandleFunc("/login", auth.LoginHandler)
+	http.HandleFunc("/logout", auth.LogoutHandler)
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
-	http.HandleFunc("/ws", app.handleWebSocket)
+	http.Handle("/ws", auth.RequireAuthFunc(app.handleWebSocket))
+	http.Handle("GET /api/v1/jobs", auth.RequireAuthFunc(app.listJobs))
+	http.Handle("GET /api/v1/jobs/{id}", auth.RequireAuthFunc(app.getJob))
+	http.Handle("DELETE /api/v1/jobs/{id}", auth.RequireAuthFunc(app.cancelJob))
+	http.Handle("GET /api/v1/jobs/{id}/logs", auth.RequireAuthFunc(app.jobLogs))
+	http.Handle("GET /api/v1/files/{id}/detections", auth.RequireAuthFunc(app.listDetections))
+	http.Handle("GET /api/v1/search", auth.RequireAuthFunc(app.searchUploads))
+	http.Handle("GET /api/v1/batches/{id}", auth.RequireAuthFunc(app.getBatch))
+	http.Handle("GET /api/v1/models", auth.RequireAuthFunc(app.listModels))
+	http.Handle("GET /api/v1/queue", auth.RequireAuthFunc(app.queueStatus))
+
+	uploads := app.newResumableUploads(getenv("TUS_DIR", filepath.Join(app.UploadDir, ".tus")))
+	go uploads.RunCleanup(context.Background(), time.Hour)
+	http.Handle("/api/v1/uploads", auth.RequireAuth(uploads))
+	http.Handle("/api/v1/uploads/", auth.RequireAuth(uploads))
 
 	// Swagger UI
 	http.Handle("/swagger/", httpSwagger.WrapHandler)
@@ -96,85 +218,400 @@ func (a *App) listenForUploadNotifications() {
 	log.Println("Upload notification listener stopped.") // Ez csak akkor fut le, ha a channel lezárul.
 }
 
-func (a *App) messageHandler(key, value []byte) error {
-	log.Printf("Üzenet feldolgozása: Key: %s, Value: %s\n", string(key), string(value))
+func (a *App) handleJobStatus(status kubeapi.JobStatus) {
+	log.Printf("[JOB] upload %s: job %s is %s", status.UploadID, status.JobName, status.State)
+	job, err := auth.GetJobByUpload(status.UploadID)
+	if err != nil {
+		log.Printf("Failed to look up job of upload %s: %v", status.UploadID, err)
+		return
+	}
+	if job.State == string(kubeapi.JobQueued) || (job.JobName != "" && job.JobName != status.JobName) ||
+		(job.Cluster != "" && job.Cluster != status.Cluster) {
+		// A cluster Job of an earlier attempt.
+		return
+	}
+	if status.State == kubeapi.JobFailed && a.retryFailedJob(job, status) {
+		return
+	}
+	if status.Blocked {
+		// The pod would otherwise wait for its image until the deadline.
+		if err := a.deleteClusterJob(job, status.JobName); err != nil {
+			log.Printf("Failed to delete blocked job %s: %v", status.JobName, err)
+		}
+	}
+
+	if status.State == kubeapi.JobSucceeded {
+		// Collected before the job is marked succeeded, the detections are
+		// there once that is announced.
+		if err := a.collectDetections(context.Background(), status.UploadID); err != nil {
+			log.Printf("Failed to collect detections for upload %s: %v", status.UploadID, err)
+		}
+	}
 
-	var msg map[string]string
-	err := json.Unmarshal(value, &msg)
+	announced := []events.Event{jobStateEvent(job, status.State, status.Reason, status.Message)}
+	if status.State.Terminal() {
+		announced = detectionFinishedEvents(job, status.State, status.Reason, status.Message)
+	}
+	// Other replicas observing the same change leave announcing it to the
+	// one that recorded it.
+	entered, err := auth.UpdateJobByUpload(status.UploadID, auth.JobUpdate{
+		JobName:    status.JobName,
+		PodName:    status.PodName,
+		State:      string(status.State),
+		StartedAt:  status.StartedAt,
+		FinishedAt: status.FinishedAt,
+		ExitCode:   status.ExitCode,
+		Error:      status.Message,
+		Reason:     status.Reason,
+	}, a.outbox(announced...)...)
+	if err != nil {
+		log.Printf("Failed to record state of job %s: %v", status.JobName, err)
+	}
+	if entered {
+		a.announce(announced...)
+	}
+	if !status.State.Terminal() {
+		return
+	}
+	a.Queue.Notify()
+	if entered {
+		// The pod, and with it its log, is deleted with the Job once the
+		// garbage collector removes it.
+		go a.archiveLogs(job, status.PodName)
+		if status.State == kubeapi.JobFailed || status.State == kubeapi.JobTimedOut {
+			if err := auth.AddJobAttempt(job.ID, attemptOf(status)); err != nil {
+				log.Printf("Failed to record attempt of job %s: %v", job.ID, err)
+			}
+		}
+	}
+	record, err := auth.GetFile(status.UploadID)
 	if err != nil {
-		log.Printf("Failed to unmarshal message value: %v", err)
-		return err
+		log.Printf("Failed to look up upload %s: %v", status.UploadID, err)
+		return
+	}
+	if record.BatchID != "" {
+		a.notifyBatchDone(record)
 	}
+}
+
+// notifyUser sends a notification to the WebSocket connections of username only.
+func (a *App) notifyUser(username, notification string) {
 	a.WsMutex.Lock()
-	for conn := range a.WsConnections {
-		err = conn.WriteJSON(msg)
-		if err != nil {
-			log.Printf("Failed to send message to WebSocket connection: %v", err)
+	defer a.WsMutex.Unlock()
+	for conn, owner := range a.WsConnections {
+		if owner != username {
+			continue
+		}
+		if err := conn.WriteJSON(map[string]string{"message": notification}); err != nil {
+			log.Printf("Failed to send notification to WebSocket connection: %v", err)
 			delete(a.WsConnections, conn)
 			conn.Close()
 		}
 	}
-	a.WsMutex.Unlock()
+}
+
+// messageHandler delivers the events consumed from Kafka, which
+// kafka.MyKafka.RelayOutbox published from the outbox, see App.outbox.
+// Events of types or versions this build does not know are skipped.
+func (a *App) messageHandler(key, value []byte) error {
+	env, event, err := events.Decode(value)
+	if errors.Is(err, events.ErrUnknownType) || errors.Is(err, events.ErrUnsupportedVersion) {
+		log.Printf("Skipping event %s from %s: %v", env.ID, env.Source, err)
+		return nil
+	}
+	if err != nil {
+		// Decoding it again would fail the same way.
+		return kafka.Permanent(fmt.Errorf("decoding event %s: %w", key, err))
+	}
+	log.Printf("Received %s event %s from %s", env.Type, env.ID, env.Source)
+	a.deliverEvent(event)
 	return nil
 }
 
+// currentUser loads the account of the authenticated caller.
+func currentUser(w http.ResponseWriter, r *http.Request) (*auth.User, bool) {
+	username, _ := auth.UsernameFromContext(r.Context())
+	user, err := auth.GetUser(username)
+	if errors.Is(err, auth.ErrUserNotFound) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to load user %s: %v", username, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return user, true
+}
+
+// lookupFile loads an upload the caller may access. Files of other users are
+// reported as not found.
+func lookupFile(w http.ResponseWriter, r *http.Request, id string) (*auth.File, bool) {
+	user, ok := currentUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	record, err := auth.GetFile(id)
+	if errors.Is(err, auth.ErrFileNotFound) || (err == nil && !user.CanAccess(record.Owner)) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to get file %s: %v", id, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return record, true
+}
+
+// detectedDir is where the detector writes its results for an upload.
+// Uploads stored before results were kept per model share one directory.
+func detectedDir(record *auth.File) string {
+	if record.ResultsDir != "" {
+		return record.ResultsDir
+	}
+	return path.Join(path.Dir(record.StoredPath), "detected")
+}
+
+// resultsDir is where a run with cfg writes the results for the content
+// stored at storedPath, so that runs with other settings do not mix.
+func resultsDir(storedPath string, cfg auth.JobConfig) string {
+	run := fmt.Sprintf("%s-conf%g-iou%g-img%d", cfg.Model, cfg.Conf, cfg.IoU, cfg.ImgSize)
+	return path.Join(path.Dir(storedPath), "detected", run)
+}
+
+// index serves the login page and accepts authenticated uploads.
+func (a *App) index(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		auth.RequireAuthFunc(a.uploadFile).ServeHTTP(w, r)
+		return
+	}
+	http.ServeFile(w, r, "static/login.html")
+}
+
 // @Summary Upload a File
-// @Description Uploads a file to the server.
+// @Description Uploads a file to the server. Several files, or .zip and .tar.gz archives of images, are grouped into a batch.
 // @Accept multipart/form-data
-// @Produce plain
-// @Param file formData file true "File to upload"
-// @Success 200 {string} string "File uploaded successfully"
+// @Produce json
+// @Param file formData file true "File or archive to upload, may be repeated"
+// @Param model formData string false "Detection model, see /api/v1/models"
+// @Param conf formData number false "Minimum confidence (0-1), defaults to the model's"
+// @Param iou formData number false "NMS IoU threshold (0-1), defaults to the model's"
+// @Param img_size formData int false "Inference size in pixels, a multiple of 32"
+// @Param priority formData int false "Queue priority from -10 to 10, positive values are reserved for admins" default(0)
+// @Success 202 {object} db.Job "Detection job created for a single upload"
+// @Success 202 {object} batchResponse "Batch created for several files or an archive"
 // @Failure 400 {string} string "Bad request"
+// @Failure 401 {string} string "Unauthorized"
 // @Failure 500 {string} string "Internal server error"
 // @Router / [post]
 func (a *App) uploadFile(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodPost {
-		file, header, err := r.FormFile("file")
-		if err != nil {
-			http.Error(w, "Unable to get file", http.StatusBadRequest)
-			return
-		}
-		defer file.Close()
+	user, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
 
-		filepath := filepath.Join("/mnt/data", header.Filename)
-		out, err := os.Create(filepath)
-		if err != nil {
-			http.Error(w, "Unable to create file", http.StatusInternalServerError)
+	if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+		http.Error(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	headers := r.MultipartForm.File["file"]
+	if len(headers) == 0 {
+		http.Error(w, "Unable to get file", http.StatusBadRequest)
+		return
+	}
+	opts, err := a.parseUploadOptions(user, r.FormValue)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if isBatchUpload(headers) {
+		batch, jobs, err := a.processBatch(r.Context(), user, opts, headers)
+		if isArchiveError(err) || isInvalidOption(err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		defer out.Close()
-
-		_, err = io.Copy(out, file)
 		if err != nil {
-			http.Error(w, "Unable to save file", http.StatusInternalServerError)
+			log.Printf("Failed to process batch upload: %v", err)
+			http.Error(w, "Unable to process upload", http.StatusInternalServerError)
 			return
 		}
-
-		_, err = a.KubeClient.CreatePod(header.Filename, a.PvcName, a.Namespace)
+		progress, err := auth.GetBatchProgress(batch.ID, terminalJobStates)
 		if err != nil {
-			log.Printf("Failed to create Kubernetes pod for file '%s': %v", header.Filename, err)
-			http.Error(w, "File uploaded but failed to start processing job.", http.StatusInternalServerError)
+			log.Printf("Failed to get progress of batch %s: %v", batch.ID, err)
+			http.Error(w, "Unable to get batch", http.StatusInternalServerError)
 			return
 		}
+		writeJSON(w, http.StatusAccepted, batchResponse{Batch: batch, Progress: progress, Summary: []auth.ClassSummary{}, Jobs: jobs})
+		return
+	}
 
-		notificationMsg := fmt.Sprintf("File '%s' uploaded, by an other user", header.Filename)
-		a.UploadNotificationChan <- notificationMsg
-		w.Write([]byte("File uploaded successfully!"))
+	header := headers[0]
+	file, err := header.Open()
+	if err != nil {
+		http.Error(w, "Unable to get file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	job, err := a.processUpload(r.Context(), user, opts, header.Filename, header.Header.Get("Content-Type"), file)
+	if errors.Is(err, errInvalidFilename) {
+		http.Error(w, "Invalid file name", http.StatusBadRequest)
+		return
+	}
+	if isInvalidOption(err) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to process upload '%s': %v", header.Filename, err)
+		http.Error(w, "Unable to process upload", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+var errInvalidFilename = errors.New("invalid file name")
+
+// uploadName returns the name an upload called filename is stored under, or
+// an error if the model of opts cannot process it.
+func uploadName(opts uploadOptions, filename string) (string, error) {
+	name := filepath.Base(filename)
+	if name == "." || name == string(filepath.Separator) {
+		return "", errInvalidFilename
+	}
+	if !opts.Model.Supports(name) {
+		return "", fmt.Errorf("%w: %s cannot process %s", models.ErrUnsupportedInput, opts.Model.Name, name)
+	}
+	return name, nil
+}
+
+// processUpload stores an uploaded file of user and queues its detection job
+// with the model, settings and priority of opts. Content that was already
+// processed with the same model and settings reuses the earlier results
+// instead of queueing a new job.
+func (a *App) processUpload(ctx context.Context, user *auth.User, opts uploadOptions, filename, contentType string, r io.Reader) (*auth.Job, error) {
+	name, err := uploadName(opts, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &auth.File{
+		ID:           uuid.NewString(),
+		Owner:        user.Username,
+		OriginalName: name,
+		ContentType:  contentType,
+		BatchID:      opts.BatchID,
+	}
+	if record.ContentType == "" {
+		record.ContentType = "application/octet-stream"
+	}
+
+	content, err := hashUpload(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading file: %w", err)
+	}
+	defer content.Close()
+	record.SHA256 = content.SHA256
+	record.Size = content.Size
+
+	job := &auth.Job{
+		ID:        uuid.NewString(),
+		UploadID:  record.ID,
+		Owner:     user.Username,
+		Filename:  name,
+		JobConfig: opts.jobConfig(),
+		Priority:  opts.Priority,
+		State:     string(kubeapi.JobQueued),
+	}
+
+	processed, err := auth.FindProcessedFile(record.SHA256, job.JobConfig)
+	if err != nil && !errors.Is(err, auth.ErrFileNotFound) {
+		return nil, fmt.Errorf("looking up processed content: %w", err)
+	}
+	if processed != nil {
+		// Sharing the stored object also shares the annotated outputs.
+		record.StoredPath = processed.StoredPath
+		record.ResultsDir = processed.ResultsDir
 	} else {
-		http.ServeFile(w, r, "static/login.html")
+		record.StoredPath = blobKey(record.SHA256, name)
+		record.ResultsDir = resultsDir(record.StoredPath, job.JobConfig)
+		if err := a.storeBlob(ctx, record.StoredPath, content, record.ContentType); err != nil {
+			return nil, fmt.Errorf("storing file: %w", err)
+		}
+	}
+	if err := auth.CreateFile(record); err != nil {
+		return nil, fmt.Errorf("recording file: %w", err)
+	}
+
+	if processed != nil {
+		if err := a.reuseDetections(job, record, processed); err != nil {
+			return nil, err
+		}
+		return job, nil
+	}
+
+	uploaded := &events.UploadCreated{
+		UploadID: record.ID,
+		Owner:    record.Owner,
+		Filename: name,
+		BatchID:  record.BatchID,
+	}
+	if err := auth.CreateJob(job, a.outbox(uploaded)...); err != nil {
+		return nil, fmt.Errorf("recording job: %w", err)
+	}
+	a.Queue.Notify()
+	a.announce(uploaded)
+	return job, nil
+}
+
+// reuseDetections records job for record as succeeded with the detections of
+// processed, an earlier upload of the same content.
+func (a *App) reuseDetections(job *auth.Job, record, processed *auth.File) error {
+	now := time.Now()
+	job.State = string(kubeapi.JobSucceeded)
+	job.ReusedFrom = processed.ID
+	job.FinishedAt = &now
+	// The job is recorded last, so that it never finished without its
+	// detections.
+	if err := auth.CopyDetections(processed.ID, record.ID); err != nil {
+		return fmt.Errorf("copying detections: %w", err)
+	}
+	finished := detectionFinishedEvents(job, kubeapi.JobSucceeded, "", "already processed")
+	if err := auth.CreateJob(job, a.outbox(finished...)...); err != nil {
+		return fmt.Errorf("recording job: %w", err)
 	}
+	log.Printf("Upload %s has the same content as upload %s, reusing its detections", record.ID, processed.ID)
+
+	a.announce(finished...)
+	if record.BatchID != "" {
+		a.notifyBatchDone(record)
+	}
+	return nil
 }
 
 // @Summary List Files
-// @Description Returns a list of uploaded files.
+// @Description Returns a list of the caller's uploaded files. Admins see every file.
 // @Produce html
 // @Success 200 {string} string "A list of files"
 // @Failure 500 {string} string "Internal server error"
 // @Router /lists [get]
 func listFiles(w http.ResponseWriter, r *http.Request) {
-	files, err := os.ReadDir("/mnt/data")
+	user, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	owner := user.Username
+	if user.IsAdmin {
+		owner = ""
+	}
+
+	files, err := auth.ListFiles(owner)
 	if err != nil {
-		http.Error(w, "Unable to read directory", http.StatusInternalServerError)
+		log.Printf("Failed to list files: %v", err)
+		http.Error(w, "Unable to list files", http.StatusInternalServerError)
 		return
 	}
 
@@ -192,102 +629,99 @@ func listFiles(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary Display Image
-// @Description Serves the requested image file.
-// @Param filename path string true "The name of the file to display"
-// @Produce octet-stream
-// @Success 200 {file} file "The requested file"
+// @Description Shows an uploaded file together with its detection results.
+// @Param id path string true "Upload ID"
+// @Produce html
+// @Success 200 {string} string "The file page"
 // @Failure 404 {string} string "File not found"
 // @Failure 500 {string} string "Internal server error"
-// @Router /lists/{filename} [get]
+// @Router /lists/{id} [get]
 func (a *App) displayImage(w http.ResponseWriter, r *http.Request) {
-	filename := r.URL.Path[len("/lists/"):]
-	path := filepath.Join("/mnt/data", filename)
-	fileInfo, err := os.Stat(path)
-	if os.IsNotExist(err) {
-		http.Error(w, "File not found", http.StatusNotFound)
-		return
-	}
-	if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	record, ok := lookupFile(w, r, strings.TrimPrefix(r.URL.Path, "/lists/"))
+	if !ok {
 		return
 	}
 
-	if fileInfo.IsDir() {
-		files, err := os.ReadDir(path)
-		if err != nil {
-			http.Error(w, "Failed to read directory", http.StatusInternalServerError)
-			return
-		}
-
-		html := `
-		<!DOCTYPE html>
-		<html lang="en">
-		<head>
-			<meta charset="UTF-8">
-			<meta name="viewport" content="width=device-width, initial-scale=1.0">
-			<title>` + filename + ` - Könyvtár</title>
-		</head>
-		<body>
-			<a href="/lists" style="text-decoration: none; color: blue; font-size: 16px;">Vissza a listához</a>
-			<h1>` + filename + ` - Könyvtár tartalma</h1>
-			<ul>
-		`
-		for _, file := range files {
-			if !strings.HasPrefix(file.Name(), ".") {
-				link := filepath.Join("/lists", filename, file.Name())
-				html += fmt.Sprintf(`<li><a href="%s">%s</a></li>`, link, file.Name())
-			}
-		}
-		html += `
-			</ul>
-		</body>
-		</html>
-		`
-		w.Header().Set("Content-Type", "text/html")
-		w.Write([]byte(html))
+	results, err := a.listDetectedImages(r.Context(), record)
+	if err != nil {
+		log.Printf("Failed to list results of upload %s: %v", record.ID, err)
+		http.Error(w, "Failed to read directory", http.StatusInternalServerError)
 		return
 	}
 
-	html := `
+	title := html.EscapeString(record.OriginalName)
+	page := `
 	<!DOCTYPE html>
 	<html lang="en">
 	<head>
 	    <meta charset="UTF-8">
 	    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-	    <title>` + filename + ` - Kép</title>
+	    <title>` + title + ` - Kép</title>
 	</head>
 	<body>
 	    <a href="/lists" style="text-decoration: none; color: blue; font-size: 16px;">Vissza a listához</a>
-	    <h1>` + filename + ` - Kép</h1>
-	    <img src="/files/` + filename + `" alt="` + filename + ` kép">
+	    <h1>` + title + ` - Kép</h1>
+	    <img src="/files/` + record.ID + `" alt="` + title + ` kép">
+	    <h2>Felismerés eredménye</h2>
+	    <p><a href="/api/v1/files/` + record.ID + `/detections">Felismert objektumok (JSON)</a></p>
+	`
+	for _, result := range results {
+		name := path.Base(result.Key)
+		link := "/files/" + record.ID + "/detected/" + url.PathEscape(name)
+		page += fmt.Sprintf(`<p><img src="%s" alt="%s"></p>`, link, html.EscapeString(name))
+	}
+	page += `
 	</body>
 	</html>
 	`
 
 	w.Header().Set("Content-Type", "text/html")
-	w.Write([]byte(html))
+	w.Write([]byte(page))
 }
 
 // @Summary Serve File
-// @Description Serves the actual file content.
-// @Param filename path string true "The name of the file"
+// @Description Serves an uploaded file, or one of its detection outputs when a sub-path is given.
+// @Param id path string true "Upload ID"
 // @Produce octet-stream
 // @Success 200 {file} file "The requested file"
 // @Failure 404 {string} string "File not found"
-// @Router /files/{filename} [get]
-func serveFile(w http.ResponseWriter, r *http.Request) {
-	filename := r.URL.Path[len("/files/"):]
-	path := filepath.Join("/mnt/data", filename)
+// @Router /files/{id} [get]
+func (a *App) serveFile(w http.ResponseWriter, r *http.Request) {
+	id, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/files/"), "/")
+	record, ok := lookupFile(w, r, id)
+	if !ok {
+		return
+	}
 
-	if _, err := os.Stat(path); os.IsNotExist(err) {
+	key := record.StoredPath
+	if result, ok := strings.CutPrefix(sub, "detected/"); ok {
+		key = path.Join(detectedDir(record), path.Clean("/"+result))
+	} else if sub != "" {
+		key = path.Join(path.Dir(record.StoredPath), path.Clean("/"+sub))
+	}
+	rc, info, err := a.Storage.Get(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
 		http.Error(w, "File not found", http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		log.Printf("Failed to read object %s: %v", key, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
 
-	http.ServeFile(w, r, path)
+	w.Header().Set("Content-Type", info.ContentType)
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, path.Base(key), info.ModTime, rs)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	io.Copy(w, rc)
 }
 
 func (a *App) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	username, _ := auth.UsernameFromContext(r.Context())
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println(err)
@@ -296,8 +730,9 @@ func (a *App) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	defer conn.Close()
 
 	a.WsMutex.Lock()
-	a.WsConnections[conn] = true
+	a.WsConnections[conn] = username
 	a.WsMutex.Unlock()
+	log.Printf("WebSocket connection opened for user %s", username)
 
 	for {
 		_, _, err := conn.ReadMessage()
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	auth "helloworld/db"
+	"helloworld/models"
+)
+
+// uploadOptions carry what the client chose for an upload.
+type uploadOptions struct {
+	// BatchID is empty for uploads that are not part of a batch.
+	BatchID  string
+	Model    *models.Model
+	Settings models.Settings
+	Priority int
+}
+
+func (o uploadOptions) jobConfig() auth.JobConfig {
+	return auth.JobConfig{
+		Model:   o.Model.Name,
+		Conf:    o.Settings.Conf,
+		IoU:     o.Settings.IoU,
+		ImgSize: o.Settings.ImgSize,
+	}
+}
+
+// newModelRegistry loads the models listed in MODELS_FILE, or the built-in
+// ones when it is unset.
+func newModelRegistry() (*models.Registry, error) {
+	if filename := os.Getenv("MODELS_FILE"); filename != "" {
+		return models.Load(filename)
+	}
+	return models.Builtin()
+}
+
+// parseUploadOptions reads the model, conf, iou, img_size and priority
+// parameters of an upload of user through get and validates them.
+func (a *App) parseUploadOptions(user *auth.User, get func(string) string) (uploadOptions, error) {
+	var opts uploadOptions
+	model, err := a.Models.Get(get("model"))
+	if err != nil {
+		return opts, err
+	}
+	priority, err := parsePriority(user, get("priority"))
+	if err != nil {
+		return opts, err
+	}
+
+	var s models.Settings
+	if v := get("conf"); v != "" {
+		if s.Conf, err = strconv.ParseFloat(v, 64); err != nil {
+			return opts, fmt.Errorf("%w: conf must be a number", models.ErrInvalidSettings)
+		}
+	}
+	if v := get("iou"); v != "" {
+		if s.IoU, err = strconv.ParseFloat(v, 64); err != nil {
+			return opts, fmt.Errorf("%w: iou must be a number", models.ErrInvalidSettings)
+		}
+	}
+	if v := get("img_size"); v != "" {
+		if s.ImgSize, err = strconv.Atoi(v); err != nil {
+			return opts, fmt.Errorf("%w: img_size must be an integer", models.ErrInvalidSettings)
+		}
+	}
+	if s, err = model.Resolve(s); err != nil {
+		return opts, err
+	}
+	return uploadOptions{Model: model, Settings: s, Priority: priority}, nil
+}
+
+// isInvalidOption reports whether err was caused by an option the client
+// chose.
+func isInvalidOption(err error) bool {
+	return errors.Is(err, models.ErrUnknownModel) || errors.Is(err, models.ErrInvalidSettings) ||
+		errors.Is(err, models.ErrUnsupportedInput) || errors.Is(err, errInvalidPriority)
+}
+
+// @Summary List Models
+// @Description Returns the detection models an upload can choose from, with their default settings.
+// @Produce json
+// @Success 200 {object} models.Registry
+// @Router /api/v1/models [get]
+func (a *App) listModels(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Models)
+}
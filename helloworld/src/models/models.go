@@ -0,0 +1,203 @@
+// Package models describes the detection models uploads can be processed
+// with and renders the detector command line for a job.
+package models
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed models.yaml
+var builtin []byte
+
+var (
+	ErrUnknownModel     = errors.New("unknown model")
+	ErrInvalidSettings  = errors.New("invalid detection settings")
+	ErrUnsupportedInput = errors.New("input type not supported by model")
+)
+
+// Settings tune a single detection run.
+type Settings struct {
+	Conf    float64 `yaml:"conf" json:"conf"`
+	IoU     float64 `yaml:"iou" json:"iou"`
+	ImgSize int     `yaml:"img_size" json:"img_size"`
+}
+
+// Model is one entry of the registry.
+type Model struct {
+	Name        string   `yaml:"name" json:"name"`
+	Description string   `yaml:"description" json:"description,omitempty"`
+	Image       string   `yaml:"image" json:"image"`
+	Weights     string   `yaml:"weights" json:"weights"`
+	Command     []string `yaml:"command" json:"-"`
+	Defaults    Settings `yaml:"defaults" json:"defaults"`
+	MaxImgSize  int      `yaml:"max_img_size" json:"max_img_size"`
+	// InputTypes lists the accepted file extensions. Empty accepts any file.
+	InputTypes []string `yaml:"input_types" json:"input_types"`
+	// ClusterSelector limits the clusters the model runs in to those with
+	// these labels, e.g. clusters with GPUs.
+	ClusterSelector map[string]string `yaml:"cluster_selector" json:"cluster_selector,omitempty"`
+
+	command []*template.Template
+}
+
+// Paths tell the detector where to read its input and write its results.
+type Paths struct {
+	Source  string
+	Project string
+	Name    string
+}
+
+type commandData struct {
+	Paths
+	Settings
+	Weights string
+}
+
+// Registry holds the configured models.
+type Registry struct {
+	Default string   `yaml:"default" json:"default"`
+	Models  []*Model `yaml:"models" json:"models"`
+}
+
+// Builtin returns the registry shipped with the server.
+func Builtin() (*Registry, error) {
+	return Parse(builtin)
+}
+
+// Load reads a registry from the YAML file at filename.
+func Load(filename string) (*Registry, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	r, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return r, nil
+}
+
+// Parse decodes and validates a YAML registry.
+func Parse(data []byte) (*Registry, error) {
+	var r Registry
+	if err := yaml.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	if len(r.Models) == 0 {
+		return nil, errors.New("no models configured")
+	}
+
+	seen := map[string]bool{}
+	for _, m := range r.Models {
+		if err := m.init(); err != nil {
+			return nil, fmt.Errorf("model %q: %w", m.Name, err)
+		}
+		if seen[m.Name] {
+			return nil, fmt.Errorf("model %q is configured twice", m.Name)
+		}
+		seen[m.Name] = true
+	}
+	if r.Default == "" {
+		r.Default = r.Models[0].Name
+	}
+	if !seen[r.Default] {
+		return nil, fmt.Errorf("default model %q is not configured", r.Default)
+	}
+	return &r, nil
+}
+
+func (m *Model) init() error {
+	if m.Name == "" || m.Image == "" || len(m.Command) == 0 {
+		return errors.New("name, image and command are required")
+	}
+	if m.MaxImgSize == 0 {
+		m.MaxImgSize = m.Defaults.ImgSize
+	}
+	if _, err := m.Resolve(Settings{}); err != nil {
+		return fmt.Errorf("defaults: %w", err)
+	}
+	for i, arg := range m.Command {
+		tmpl, err := template.New(fmt.Sprintf("%s[%d]", m.Name, i)).Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return err
+		}
+		m.command = append(m.command, tmpl)
+	}
+	for i, ext := range m.InputTypes {
+		m.InputTypes[i] = strings.ToLower(ext)
+	}
+	return nil
+}
+
+// Get returns the model called name, or the default model when name is empty.
+func (r *Registry) Get(name string) (*Model, error) {
+	if name == "" {
+		name = r.Default
+	}
+	for _, m := range r.Models {
+		if m.Name == name {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownModel, name)
+}
+
+// Resolve fills the unset fields of s with the model's defaults and checks
+// the result.
+func (m *Model) Resolve(s Settings) (Settings, error) {
+	if s.Conf == 0 {
+		s.Conf = m.Defaults.Conf
+	}
+	if s.IoU == 0 {
+		s.IoU = m.Defaults.IoU
+	}
+	if s.ImgSize == 0 {
+		s.ImgSize = m.Defaults.ImgSize
+	}
+
+	switch {
+	case !(s.Conf > 0 && s.Conf <= 1): // Also rejects NaN
+		return s, fmt.Errorf("%w: conf must be in (0, 1]", ErrInvalidSettings)
+	case !(s.IoU > 0 && s.IoU <= 1):
+		return s, fmt.Errorf("%w: iou must be in (0, 1]", ErrInvalidSettings)
+	case s.ImgSize < 32 || s.ImgSize%32 != 0 || s.ImgSize > m.MaxImgSize:
+		return s, fmt.Errorf("%w: img_size must be a multiple of 32 between 32 and %d", ErrInvalidSettings, m.MaxImgSize)
+	}
+	return s, nil
+}
+
+// Supports reports whether the model accepts the file called filename.
+func (m *Model) Supports(filename string) bool {
+	if len(m.InputTypes) == 0 {
+		return true
+	}
+	ext := strings.ToLower(path.Ext(filename))
+	for _, t := range m.InputTypes {
+		if t == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// Args renders the detector command line for one run.
+func (m *Model) Args(p Paths, s Settings) ([]string, error) {
+	data := commandData{Paths: p, Settings: s, Weights: m.Weights}
+	args := make([]string, len(m.command))
+	for i, tmpl := range m.command {
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			return nil, err
+		}
+		args[i] = b.String()
+	}
+	return args, nil
+}
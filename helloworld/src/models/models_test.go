@@ -0,0 +1,68 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	r, err := Builtin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := r.Get("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := m.Resolve(Settings{Conf: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Settings{Conf: 0.5, IoU: m.Defaults.IoU, ImgSize: m.Defaults.ImgSize}); s != want {
+		t.Errorf("Resolve = %+v, want %+v", s, want)
+	}
+
+	for _, s := range []Settings{
+		{Conf: -0.1},
+		{Conf: 1.5},
+		{Conf: math.NaN()},
+		{IoU: math.NaN()},
+		{IoU: math.Inf(1)},
+		{ImgSize: 100},
+		{ImgSize: 16},
+		{ImgSize: m.MaxImgSize + 32},
+	} {
+		if _, err := m.Resolve(s); !errors.Is(err, ErrInvalidSettings) {
+			t.Errorf("Resolve(%+v) = %v, want ErrInvalidSettings", s, err)
+		}
+	}
+}
+
+func TestParseRejectsInvalidDefaults(t *testing.T) {
+	_, err := Parse([]byte(`
+models:
+  - name: bad
+    image: detector
+    command: [detect]
+    defaults: {conf: 2, iou: 0.5, img_size: 640}
+`))
+	if !errors.Is(err, ErrInvalidSettings) {
+		t.Errorf("Parse = %v, want ErrInvalidSettings", err)
+	}
+}
+
+func TestSupports(t *testing.T) {
+	r, err := Builtin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ := r.Get("")
+	if !m.Supports("clip.MP4") {
+		t.Error("extensions should match case-insensitively")
+	}
+	if m.Supports("notes.txt") {
+		t.Error("a text file should not be supported")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	auth "helloworld/db"
+	"helloworld/kubeapi"
+)
+
+const (
+	defaultMaxInFlight = 20
+	defaultMaxPerUser  = 4
+
+	// queuePollInterval bounds how long queued jobs wait when a wake-up was
+	// missed, e.g. because another replica finished the blocking job.
+	queuePollInterval = 10 * time.Second
+	// staleClaimAge is how long a claimed job may go without a cluster Job
+	// before it is queued again.
+	staleClaimAge = 5 * time.Minute
+
+	maxPriority = 10
+)
+
+// jobQueue hands queued detection jobs to the cluster without exceeding its
+// limits. The queue itself lives in the jobs table.
+type jobQueue struct {
+	limits auth.QueueLimits
+	wake   chan struct{}
+}
+
+func newJobQueue(limits auth.QueueLimits) *jobQueue {
+	return &jobQueue{limits: limits, wake: make(chan struct{}, 1)}
+}
+
+// queueLimitsFromEnv reads QUEUE_MAX_IN_FLIGHT and QUEUE_MAX_PER_USER. Zero
+// disables a limit.
+func queueLimitsFromEnv() (auth.QueueLimits, error) {
+	limits := auth.QueueLimits{MaxInFlight: defaultMaxInFlight, MaxPerUser: defaultMaxPerUser}
+	for key, limit := range map[string]*int{
+		"QUEUE_MAX_IN_FLIGHT": &limits.MaxInFlight,
+		"QUEUE_MAX_PER_USER":  &limits.MaxPerUser,
+	} {
+		v := os.Getenv(key)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return limits, fmt.Errorf("invalid %s %q", key, v)
+		}
+		*limit = n
+	}
+	return limits, nil
+}
+
+// Notify wakes the dispatcher, e.g. after a job was queued or finished.
+func (q *jobQueue) Notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runQueue dispatches queued jobs until ctx is done. Jobs a stopped replica
+// claimed are queued again by the garbage collector, see collectGarbage.
+func (a *App) runQueue(ctx context.Context) {
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+	for {
+		a.dispatchQueued(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.Queue.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatchQueued starts queued jobs until the queue is empty or a limit is
+// reached.
+func (a *App) dispatchQueued(ctx context.Context) {
+	for {
+		job, err := auth.ClaimQueuedJob(a.Queue.limits)
+		if errors.Is(err, auth.ErrJobNotFound) {
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to claim queued job: %v", err)
+			return
+		}
+		a.dispatch(ctx, job)
+	}
+}
+
+func (a *App) dispatch(ctx context.Context, job *auth.Job) {
+	record, err := auth.GetFile(job.UploadID)
+	if err == nil {
+		err = a.startJob(ctx, record, job)
+	}
+	if errors.Is(err, kubeapi.ErrNoCapacity) {
+		// Let jobs that other clusters can take go first.
+		if err := auth.ReleaseJob(job.ID, time.Now().Add(queuePollInterval)); err != nil {
+			log.Printf("Failed to requeue job %s: %v", job.ID, err)
+		}
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to start job %s: %v", job.ID, err)
+		failed := detectionFinishedEvents(job, kubeapi.JobFailed, "", "could not be started")
+		if err := auth.SetJobState(job.ID, string(kubeapi.JobFailed), err.Error(), a.outbox(failed...)...); err != nil {
+			log.Printf("Failed to mark job %s failed: %v", job.ID, err)
+			return
+		}
+		a.announce(failed...)
+		return
+	}
+	if err := auth.SetJobName(job.ID, job.JobName); err != nil {
+		log.Printf("Failed to record job name for job %s: %v", job.ID, err)
+	}
+}
+
+// parsePriority validates the priority a user asked for. Only admins may
+// raise the priority of their jobs above the default.
+func parsePriority(user *auth.User, v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	priority, err := strconv.Atoi(v)
+	if err != nil || priority < -maxPriority || priority > maxPriority {
+		return 0, fmt.Errorf("%w: priority must be an integer between %d and %d", errInvalidPriority, -maxPriority, maxPriority)
+	}
+	if priority > 0 && !user.IsAdmin {
+		return 0, fmt.Errorf("%w: only admins may use a positive priority", errInvalidPriority)
+	}
+	return priority, nil
+}
+
+var errInvalidPriority = errors.New("invalid priority")
+
+// @Summary Queue Status
+// @Description Returns how many detection jobs wait in the queue and how many run, overall and for the caller.
+// @Produce json
+// @Success 200 {object} db.QueueStats
+// @Failure 500 {string} string "Internal server error"
+// @Router /api/v1/queue [get]
+func (a *App) queueStatus(w http.ResponseWriter, r *http.Request) {
+	username, _ := auth.UsernameFromContext(r.Context())
+	stats, err := auth.GetQueueStats(username)
+	if err != nil {
+		log.Printf("Failed to get queue stats: %v", err)
+		http.Error(w, "Unable to get queue status", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"helloworld/kafka"
+)
+
+// replayIdleTimeout ends a replay once the dead-letter topic had no new
+// message for this long.
+const replayIdleTimeout = 30 * time.Second
+
+// runReplay moves the events of the Kafka dead-letter topic back to the
+// topics they were consumed from, see kafka.MyKafka.ReplayDeadLetters. It
+// reads the same KAFKA_* variables as the server.
+func runReplay() {
+	cfg, err := kafkaConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure Kafka: %v", err)
+	}
+	bus, err := kafka.NewMyKafka(cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure Kafka: %v", err)
+	}
+	if err := bus.InitWriter(); err != nil {
+		log.Fatalf("Failed to configure Kafka: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	n, err := bus.ReplayDeadLetters(ctx, replayIdleTimeout)
+	bus.CloseWriterReader()
+	if err != nil {
+		log.Fatalf("Failed to replay dead-lettered events after %d of them: %v", n, err)
+	}
+	log.Printf("Replayed %d dead-lettered events", n)
+}
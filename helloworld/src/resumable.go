@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	auth "helloworld/db"
+	"helloworld/tus"
+)
+
+const (
+	maxResumableUploadSize = 4 << 30
+	resumableUploadTTL     = 24 * time.Hour
+)
+
+// newResumableUploads serves tus uploads at /api/v1/uploads/, keeping
+// partial uploads in dir. Detection starts once the final chunk arrived, with
+// the model, settings and priority given in the upload metadata.
+func (a *App) newResumableUploads(dir string) *tus.Handler {
+	return &tus.Handler{
+		BasePath:   "/api/v1/uploads",
+		Dir:        dir,
+		MaxSize:    maxResumableUploadSize,
+		Expiration: resumableUploadTTL,
+		Owner: func(r *http.Request) string {
+			username, _ := auth.UsernameFromContext(r.Context())
+			return username
+		},
+		ValidateMetadata: func(r *http.Request, metadata map[string]string) error {
+			username, _ := auth.UsernameFromContext(r.Context())
+			user, err := auth.GetUser(username)
+			if err != nil {
+				return err
+			}
+			_, err = a.parseUploadOptions(user, func(key string) string { return metadata[key] })
+			return err
+		},
+		OnComplete: a.completeResumableUpload,
+	}
+}
+
+func (a *App) completeResumableUpload(ctx context.Context, u *tus.Upload, data *os.File) (string, error) {
+	user, err := auth.GetUser(u.Owner)
+	if err != nil {
+		return "", err
+	}
+	filename := u.Metadata["filename"]
+	if filename == "" {
+		filename = u.ID
+	}
+	opts, err := a.parseUploadOptions(user, func(key string) string { return u.Metadata[key] })
+	if isInvalidOption(err) {
+		return "", tus.BadRequest(err)
+	}
+	if err != nil {
+		return "", err
+	}
+	job, err := a.processUpload(ctx, user, opts, filename, u.Metadata["filetype"], data)
+	if isInvalidOption(err) || errors.Is(err, errInvalidFilename) {
+		return "", tus.BadRequest(err)
+	}
+	if err != nil {
+		return "", err
+	}
+	return job.ID, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	auth "helloworld/db"
+	"helloworld/kubeapi"
+)
+
+const (
+	defaultMaxRetries     = 3
+	defaultRetryBaseDelay = 30 * time.Second
+	maxRetryDelay         = 15 * time.Minute
+)
+
+// retryPolicy decides how often and when transient failures are retried.
+type retryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+}
+
+// retryPolicyFromEnv reads JOB_MAX_RETRIES and JOB_RETRY_BASE_DELAY.
+func retryPolicyFromEnv() (retryPolicy, error) {
+	p := retryPolicy{MaxRetries: defaultMaxRetries, BaseDelay: defaultRetryBaseDelay}
+	if v := os.Getenv("JOB_MAX_RETRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("invalid JOB_MAX_RETRIES %q", v)
+		}
+		p.MaxRetries = n
+	}
+	if v := os.Getenv("JOB_RETRY_BASE_DELAY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return p, fmt.Errorf("invalid JOB_RETRY_BASE_DELAY %q", v)
+		}
+		p.BaseDelay = d
+	}
+	return p, nil
+}
+
+// delay is the wait before retry number n, starting at 1. It doubles with
+// every retry up to maxRetryDelay.
+func (p retryPolicy) delay(n int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < n && d < maxRetryDelay; i++ {
+		d *= 2
+	}
+	return min(d, maxRetryDelay)
+}
+
+func attemptOf(status kubeapi.JobStatus) auth.JobAttempt {
+	attempt := auth.JobAttempt{
+		Cluster:    status.Cluster,
+		JobName:    status.JobName,
+		PodName:    status.PodName,
+		FinishedAt: status.FinishedAt,
+		ExitCode:   status.ExitCode,
+		Reason:     status.Reason,
+		Message:    status.Message,
+		Transient:  status.Transient,
+	}
+	if !status.StartedAt.IsZero() {
+		attempt.StartedAt = &status.StartedAt
+	}
+	if attempt.FinishedAt.IsZero() {
+		attempt.FinishedAt = time.Now()
+	}
+	return attempt
+}
+
+// retryFailedJob queues the job that failed with status for another attempt
+// when the failure is transient and retries are left. It reports whether the
+// job is retried. Only the replica that queued it again archives the logs of
+// the failed run and deletes its cluster Job.
+func (a *App) retryFailedJob(job *auth.Job, status kubeapi.JobStatus) bool {
+	if !status.Transient || len(job.Attempts) >= a.Retry.MaxRetries {
+		return false
+	}
+	retry := len(job.Attempts) + 1
+	delay := a.Retry.delay(retry)
+
+	queued, err := auth.RetryJob(job.ID, attemptOf(status), time.Now().Add(delay))
+	if err != nil {
+		log.Printf("Failed to queue job %s for a retry: %v", job.ID, err)
+		return false
+	}
+	if !queued {
+		return true
+	}
+	a.archiveLogs(job, status.PodName)
+	if err := a.deleteClusterJob(job, status.JobName); err != nil {
+		log.Printf("Failed to delete failed job %s after queueing a retry: %v", status.JobName, err)
+	}
+	log.Printf("Retrying job %s in %s (retry %d of %d): %s", job.ID, delay, retry, a.Retry.MaxRetries, status.Message)
+	a.notifyUser(job.Owner, fmt.Sprintf("Detection of '%s' failed (%s), retrying in %s",
+		job.Filename, status.Message, delay.Round(time.Second)))
+	return true
+}
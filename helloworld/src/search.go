@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	auth "helloworld/db"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 200
+)
+
+type searchResponse struct {
+	Total   int                 `json:"total"`
+	Limit   int                 `json:"limit"`
+	Offset  int                 `json:"offset"`
+	Results []auth.SearchResult `json:"results"`
+}
+
+// @Summary Search Uploads
+// @Description Finds uploads by detected object class and confidence.
+// @Produce json
+// @Param class query string false "Object class, e.g. person"
+// @Param min_conf query number false "Minimum detection confidence (0-1)"
+// @Param min_count query int false "Minimum number of matching detections in one image or video frame" default(1)
+// @Param from query string false "Uploaded at or after (RFC3339 or YYYY-MM-DD)"
+// @Param to query string false "Uploaded before (RFC3339, or YYYY-MM-DD for the end of that day)"
+// @Param limit query int false "Page size" default(50)
+// @Param offset query int false "Page offset" default(0)
+// @Success 200 {object} searchResponse
+// @Failure 400 {string} string "Bad request"
+// @Failure 500 {string} string "Internal server error"
+// @Router /api/v1/search [get]
+func (a *App) searchUploads(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentUser(w, r)
+	if !ok {
+		return
+	}
+	q, err := parseSearchQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !user.IsAdmin {
+		q.Owner = user.Username
+	}
+
+	results, total, err := auth.SearchUploads(q)
+	if err != nil {
+		log.Printf("Failed to search uploads: %v", err)
+		http.Error(w, "Unable to search uploads", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, searchResponse{
+		Total:   total,
+		Limit:   q.Limit,
+		Offset:  q.Offset,
+		Results: results,
+	})
+}
+
+func parseSearchQuery(r *http.Request) (auth.SearchQuery, error) {
+	params := r.URL.Query()
+	q := auth.SearchQuery{
+		Class:    params.Get("class"),
+		MinCount: 1,
+		Limit:    defaultSearchLimit,
+	}
+
+	var err error
+	if v := params.Get("min_conf"); v != "" {
+		q.MinConfidence, err = strconv.ParseFloat(v, 64)
+		if err != nil || !(q.MinConfidence >= 0 && q.MinConfidence <= 1) {
+			return q, fmt.Errorf("min_conf must be a number between 0 and 1")
+		}
+	}
+	if v := params.Get("min_count"); v != "" {
+		q.MinCount, err = strconv.Atoi(v)
+		if err != nil || q.MinCount < 1 {
+			return q, fmt.Errorf("min_count must be a positive integer")
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		q.Limit, err = strconv.Atoi(v)
+		if err != nil || q.Limit < 1 || q.Limit > maxSearchLimit {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxSearchLimit)
+		}
+	}
+	if v := params.Get("offset"); v != "" {
+		q.Offset, err = strconv.Atoi(v)
+		if err != nil || q.Offset < 0 {
+			return q, fmt.Errorf("offset must be a non-negative integer")
+		}
+	}
+	if q.From, err = parseSearchTime(params.Get("from"), false); err != nil {
+		return q, fmt.Errorf("from: %v", err)
+	}
+	if q.To, err = parseSearchTime(params.Get("to"), true); err != nil {
+		return q, fmt.Errorf("to: %v", err)
+	}
+	return q, nil
+}
+
+// parseSearchTime parses an RFC3339 time or a date. A date stands for the
+// start of that day, or with end set for the end of it, so that both bounds
+// include the days they name.
+func parseSearchTime(v string, end bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected RFC3339 or YYYY-MM-DD, got %q", v)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
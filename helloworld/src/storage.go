@@ -0,0 +1,256 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	auth "helloworld/db"
+	"helloworld/kubeapi"
+	"helloworld/models"
+	"helloworld/storage"
+)
+
+// presignSlack covers the time a job may wait to be scheduled on top of
+// PodCompletionTimeout.
+const presignSlack = 30 * time.Minute
+
+// hashedUpload is an upload whose content has been read once to compute its
+// SHA-256 hash and can be read again from the start.
+type hashedUpload struct {
+	io.ReadSeeker
+	SHA256 string
+	Size   int64
+	close  func() error
+}
+
+func (h *hashedUpload) Close() error {
+	return h.close()
+}
+
+// hashUpload hashes r. Readers that cannot seek are spooled to a temporary
+// file first.
+func hashUpload(r io.Reader) (*hashedUpload, error) {
+	hash := sha256.New()
+	if rs, ok := r.(io.ReadSeeker); ok {
+		start, err := rs.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		n, err := io.Copy(hash, rs)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := rs.Seek(start, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return &hashedUpload{ReadSeeker: rs, SHA256: hex.EncodeToString(hash.Sum(nil)), Size: n, close: func() error { return nil }}, nil
+	}
+
+	tmp, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	cleanup := func() error {
+		tmp.Close()
+		return os.Remove(tmp.Name())
+	}
+	n, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	return &hashedUpload{ReadSeeker: tmp, SHA256: hex.EncodeToString(hash.Sum(nil)), Size: n, close: cleanup}, nil
+}
+
+// blobKey is the content-addressed key of an upload with the given hash. The
+// extension of filename is kept so that the detector recognizes the format.
+func blobKey(sum, filename string) string {
+	return path.Join("blobs", sum[:2], sum, "source"+strings.ToLower(path.Ext(filename)))
+}
+
+// storeBlob stores content under key unless an earlier upload of the same
+// content already did.
+func (a *App) storeBlob(ctx context.Context, key string, content *hashedUpload, contentType string) error {
+	_, err := a.Storage.Stat(ctx, key)
+	if err == nil || !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	return a.Storage.Put(ctx, key, content, content.Size, contentType)
+}
+
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// newStorage selects the storage backend from the STORAGE_* and S3_*
+// environment variables. The local backend is the default.
+func newStorage(ctx context.Context, uploadDir string) (storage.Backend, *storage.Local, error) {
+	switch backend := getenv("STORAGE_BACKEND", "local"); backend {
+	case "local":
+		// Presigned URLs are checked by whichever replica serves them, so
+		// every replica needs the same key.
+		publicURL := os.Getenv("STORAGE_PUBLIC_URL")
+		secret := []byte(os.Getenv("STORAGE_SIGNING_KEY"))
+		if len(secret) == 0 && publicURL != "" {
+			return nil, nil, errors.New("STORAGE_SIGNING_KEY is required with STORAGE_PUBLIC_URL")
+		}
+		if len(secret) == 0 {
+			secret = make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				return nil, nil, err
+			}
+		}
+		local := storage.NewLocal(getenv("STORAGE_ROOT", uploadDir), publicURL, secret)
+		return local, local, nil
+	case "s3":
+		useSSL, err := strconv.ParseBool(getenv("S3_USE_SSL", "true"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid S3_USE_SSL: %w", err)
+		}
+		s3, err := storage.NewS3(ctx, storage.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    getenv("S3_BUCKET", "detector"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			UseSSL:    useSSL,
+		})
+		return s3, nil, err
+	default:
+		return nil, nil, fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+	}
+}
+
+// resultsArchive is the object the detector uploads its packed results to
+// when it runs against object storage.
+func resultsArchive(record *auth.File) string {
+	return detectedDir(record) + ".tar.gz"
+}
+
+// unpackResults moves the files of the results archive uploaded by the
+// detector below the upload's detected directory. Uploads processed on the
+// shared volume have no archive and are left alone.
+func (a *App) unpackResults(ctx context.Context, record *auth.File) error {
+	archiveKey := resultsArchive(record)
+	rc, _, err := a.Storage.Get(ctx, archiveKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	gz, err := gzip.NewReader(rc)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean("/" + hdr.Name)[1:]
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		if err := a.Storage.Put(ctx, path.Join(detectedDir(record), name), tr, hdr.Size, contentType); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Unpacked detection results of upload %s", record.ID)
+	return a.Storage.Delete(ctx, archiveKey)
+}
+
+// listDetectedImages returns the annotated outputs of an upload, skipping the
+// label files.
+func (a *App) listDetectedImages(ctx context.Context, record *auth.File) ([]storage.ObjectInfo, error) {
+	prefix := detectedDir(record) + "/"
+	objects, err := a.Storage.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var images []storage.ObjectInfo
+	for _, obj := range objects {
+		if !strings.Contains(strings.TrimPrefix(obj.Key, prefix), "/") {
+			images = append(images, obj)
+		}
+	}
+	return images, nil
+}
+
+// jobSpec describes the detection run of job for an upload in cluster. When
+// the backend can presign URLs the pod transfers data through them, otherwise
+// it mounts the shared volume.
+func (a *App) jobSpec(ctx context.Context, cluster *kubeapi.Cluster, record *auth.File, job *auth.Job) (kubeapi.JobSpec, error) {
+	model, err := a.Models.Get(job.Model)
+	if err != nil {
+		return kubeapi.JobSpec{}, err
+	}
+	spec := kubeapi.JobSpec{
+		JobID:     job.ID,
+		Attempt:   len(job.Attempts),
+		UploadID:  record.ID,
+		Owner:     job.Owner,
+		BatchID:   record.BatchID,
+		Filename:  record.OriginalName,
+		Model:     model,
+		Settings:  models.Settings{Conf: job.Conf, IoU: job.IoU, ImgSize: job.ImgSize},
+		Source:    record.StoredPath,
+		OutputDir: detectedDir(record),
+		PvcName:   cluster.PVC,
+		Namespace: cluster.Namespace,
+		Timeout:   a.PodCompletionTimeout,
+	}
+
+	// The URLs must stay valid until the pod may still be running.
+	expiry := a.PodCompletionTimeout + presignSlack
+	sourceURL, err := a.Storage.PresignGet(ctx, record.StoredPath, expiry)
+	if errors.Is(err, storage.ErrPresignUnsupported) {
+		if spec.PvcName == "" && cluster.ClusterConfig.Executor == kubeapi.ExecutorKubernetes {
+			return spec, fmt.Errorf("cluster %s has no shared volume and storage cannot presign URLs", cluster.Name)
+		}
+		return spec, nil
+	}
+	if err != nil {
+		return spec, err
+	}
+	resultURL, err := a.Storage.PresignPut(ctx, resultsArchive(record), expiry)
+	if err != nil {
+		return spec, err
+	}
+	spec.SourceURL = sourceURL
+	spec.ResultURL = resultURL
+	return spec, nil
+}
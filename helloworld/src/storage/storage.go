@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	ErrNotFound           = errors.New("object not found")
+	ErrPresignUnsupported = errors.New("backend cannot create presigned URLs")
+)
+
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// Backend stores uploads and detection results as objects addressed by
+// slash-separated keys.
+type Backend interface {
+	// Put stores r under key. A negative size means the length is unknown.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// List returns every object whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	// PresignGet and PresignPut return URLs that allow anyone holding them
+	// to download or upload key until expiry, without further credentials.
+	PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error)
+	PresignPut(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
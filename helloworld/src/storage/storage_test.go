@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testBackend checks the behaviour every Backend shares.
+func testBackend(t *testing.T, b Backend) {
+	ctx := context.Background()
+	for key, body := range map[string]string{
+		"alice/cat.jpg":            "cat",
+		"alice/cat/detected/1.txt": "0 0.5 0.5 0.1 0.1",
+		"bob/dog.png":              "dog",
+	} {
+		if err := b.Put(ctx, key, strings.NewReader(body), int64(len(body)), ""); err != nil {
+			t.Fatalf("Put %s: %v", key, err)
+		}
+	}
+
+	rc, info, err := b.Get(ctx, "alice/cat.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "cat" || info.Size != 3 {
+		t.Errorf("Get = %q, size %d", data, info.Size)
+	}
+	if _, _, err := b.Get(ctx, "alice/missing.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a missing key: %v, want ErrNotFound", err)
+	}
+	if _, err := b.Stat(ctx, "alice/missing.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat of a missing key: %v, want ErrNotFound", err)
+	}
+
+	if got, want := keys(t, b, "alice/"), []string{"alice/cat.jpg", "alice/cat/detected/1.txt"}; !equal(got, want) {
+		t.Errorf("List(alice/) = %v, want %v", got, want)
+	}
+	if got, want := keys(t, b, "alice/cat/"), []string{"alice/cat/detected/1.txt"}; !equal(got, want) {
+		t.Errorf("List(alice/cat/) = %v, want %v", got, want)
+	}
+
+	if err := b.Delete(ctx, "bob/dog.png"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(ctx, "bob/dog.png"); err != nil {
+		t.Errorf("deleting a missing key: %v", err)
+	}
+	if got := keys(t, b, "bob/"); len(got) != 0 {
+		t.Errorf("List after Delete = %v", got)
+	}
+
+	put, err := b.PresignPut(ctx, "carol/up.jpg", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodPut, put, strings.NewReader("uploaded"))
+	if res, err := http.DefaultClient.Do(req); err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("presigned PUT: %v %v", res, err)
+	}
+	get, err := b.PresignGet(ctx, "carol/up.jpg", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Get(get)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(data) != "uploaded" {
+		t.Errorf("presigned GET: %d %q", res.StatusCode, data)
+	}
+}
+
+func keys(t *testing.T, b Backend, prefix string) []string {
+	t.Helper()
+	objects, err := b.List(context.Background(), prefix)
+	if err != nil {
+		t.Fatalf("List(%s): %v", prefix, err)
+	}
+	var keys []string
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func equal(a, b []string) bool {
+	return strings.Join(a, ",") == strings.Join(b, ",")
+}
+
+func newTestLocal(t *testing.T) *Local {
+	t.Helper()
+	local := NewLocal(t.TempDir(), "", []byte("secret"))
+	srv := httptest.NewServer(local)
+	t.Cleanup(srv.Close)
+	local.BaseURL = srv.URL
+	return local
+}
+
+func TestLocal(t *testing.T) {
+	testBackend(t, newTestLocal(t))
+}
+
+func TestLocalListSkipsHidden(t *testing.T) {
+	local := newTestLocal(t)
+	for _, p := range []string{".tus/abc.bin", "alice/.upload-123", "alice/cat.jpg"} {
+		if err := os.MkdirAll(local.Root+"/"+p[:strings.LastIndex(p, "/")], 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(local.Root+"/"+p, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := keys(t, local, ""), []string{"alice/cat.jpg"}; !equal(got, want) {
+		t.Errorf("List = %v, want %v", got, want)
+	}
+}
+
+func TestLocalRejectsBadURLs(t *testing.T) {
+	local := newTestLocal(t)
+	if err := local.Put(context.Background(), "a.jpg", bytes.NewReader([]byte("a")), 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	get, err := local.PresignGet(context.Background(), "a.jpg", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := local.PresignGet(context.Background(), "a.jpg", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, url := range map[string]string{
+		"expired":      expired,
+		"other key":    strings.Replace(get, "/a.jpg", "/b.jpg", 1),
+		"no signature": local.BaseURL + "/a.jpg?expires=9999999999",
+	} {
+		res, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: %d, want 403", name, res.StatusCode)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, get, strings.NewReader("b"))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("PUT with a GET signature: %d, want 403", res.StatusCode)
+	}
+}
+
+// TestS3 runs against the MinIO or other S3-compatible service named by
+// S3_TEST_ENDPOINT, e.g. "localhost:9000" with S3_TEST_ACCESS_KEY and
+// S3_TEST_SECRET_KEY set to "minioadmin".
+func TestS3(t *testing.T) {
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_TEST_ENDPOINT is not set")
+	}
+	s3, err := NewS3(context.Background(), S3Config{
+		Endpoint:  endpoint,
+		Bucket:    "detector-test-" + strings.ToLower(time.Now().Format("20060102150405")),
+		AccessKey: os.Getenv("S3_TEST_ACCESS_KEY"),
+		SecretKey: os.Getenv("S3_TEST_SECRET_KEY"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBackend(t, s3)
+}
@@ -0,0 +1,456 @@
+// Package tus implements the server side of the tus 1.0 resumable upload
+// protocol with the creation, termination and expiration extensions.
+// See https://tus.io/protocols/resumable-upload.
+package tus
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	Version    = "1.0.0"
+	Extensions = "creation,termination,expiration"
+
+	offsetContentType = "application/offset+octet-stream"
+)
+
+var errNotFound = errors.New("upload not found")
+
+type badRequestError struct {
+	err error
+}
+
+func (e badRequestError) Error() string { return e.err.Error() }
+func (e badRequestError) Unwrap() error { return e.err }
+
+// BadRequest marks an error of OnComplete as caused by the upload itself,
+// e.g. a file type that cannot be processed. Such an upload is removed and
+// the client gets the error with 400 Bad Request.
+func BadRequest(err error) error {
+	if err == nil {
+		return nil
+	}
+	return badRequestError{err}
+}
+
+// Upload is the persisted state of one resumable upload.
+type Upload struct {
+	ID        string            `json:"id"`
+	Owner     string            `json:"owner"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"offset"`
+	Metadata  map[string]string `json:"metadata"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	Completed bool              `json:"completed"`
+	// Result is whatever OnComplete returned, e.g. the id of the job that
+	// processes the upload.
+	Result string `json:"result,omitempty"`
+}
+
+// Handler serves tus requests below BasePath. Partial uploads are kept in
+// Dir as "<id>.bin" next to a "<id>.info" JSON description.
+type Handler struct {
+	BasePath   string
+	Dir        string
+	MaxSize    int64
+	Expiration time.Duration
+	// Owner identifies the caller; uploads are only visible to their owner.
+	Owner func(r *http.Request) string
+	// ValidateMetadata, if set, rejects the creation of uploads whose
+	// metadata it returns an error for.
+	ValidateMetadata func(r *http.Request, metadata map[string]string) error
+	// OnComplete is called once the last chunk has been written. The data
+	// file is removed after it returns without error.
+	OnComplete func(ctx context.Context, u *Upload, data *os.File) (string, error)
+
+	locks sync.Map
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", Version)
+
+	method := r.Method
+	if override := r.Header.Get("X-HTTP-Method-Override"); override != "" && method == http.MethodPost {
+		method = override
+	}
+
+	if method == http.MethodOptions {
+		w.Header().Set("Tus-Version", Version)
+		w.Header().Set("Tus-Extension", Extensions)
+		if h.MaxSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.MaxSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != Version {
+		w.Header().Set("Tus-Version", Version)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, h.BasePath), "/")
+	switch {
+	case id == "" && method == http.MethodPost:
+		h.create(w, r)
+	case id != "" && method == http.MethodHead:
+		h.head(w, r, id)
+	case id != "" && method == http.MethodPatch:
+		h.patch(w, r, id)
+	case id != "" && method == http.MethodDelete:
+		h.terminate(w, r, id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) create(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if h.MaxSize > 0 && length > h.MaxSize {
+		http.Error(w, "Upload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	metadata, err := parseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "Invalid Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+	if h.ValidateMetadata != nil {
+		if err := h.ValidateMetadata(r, metadata); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	now := time.Now()
+	u := &Upload{
+		ID:        uuid.NewString(),
+		Owner:     h.Owner(r),
+		Length:    length,
+		Metadata:  metadata,
+		CreatedAt: now,
+		ExpiresAt: now.Add(h.Expiration),
+	}
+	if err := os.MkdirAll(h.Dir, 0o755); err != nil {
+		log.Printf("Failed to create tus directory: %v", err)
+		http.Error(w, "Unable to create upload", http.StatusInternalServerError)
+		return
+	}
+	f, err := os.Create(h.dataPath(u.ID))
+	if err != nil {
+		log.Printf("Failed to create tus upload %s: %v", u.ID, err)
+		http.Error(w, "Unable to create upload", http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+	if err := h.save(u); err != nil {
+		log.Printf("Failed to save tus upload %s: %v", u.ID, err)
+		http.Error(w, "Unable to create upload", http.StatusInternalServerError)
+		return
+	}
+
+	if length == 0 {
+		if err := h.complete(r.Context(), u); err != nil {
+			h.completeFailed(w, u, err)
+			return
+		}
+	}
+
+	w.Header().Set("Location", strings.TrimSuffix(h.BasePath, "/")+"/"+u.ID)
+	w.Header().Set("Upload-Expires", u.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) head(w http.ResponseWriter, r *http.Request, id string) {
+	u, ok := h.lookup(w, r, id)
+	if !ok {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	if !u.Completed {
+		w.Header().Set("Upload-Expires", u.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) patch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != offsetContentType {
+		http.Error(w, "Content-Type must be "+offsetContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	unlock, ok := h.lock(id)
+	if !ok {
+		http.Error(w, "Upload is being written by another request", http.StatusConflict)
+		return
+	}
+	defer unlock()
+
+	u, ok := h.lookup(w, r, id)
+	if !ok {
+		return
+	}
+	if offset != u.Offset {
+		http.Error(w, "Upload-Offset does not match", http.StatusConflict)
+		return
+	}
+
+	if !u.Completed && u.Offset < u.Length {
+		written, err := h.appendChunk(u, r.Body)
+		u.Offset += written
+		if saveErr := h.save(u); saveErr != nil {
+			log.Printf("Failed to save tus upload %s: %v", u.ID, saveErr)
+			http.Error(w, "Unable to store chunk", http.StatusInternalServerError)
+			return
+		}
+		if err != nil {
+			// The received part is kept; the client resumes from Upload-Offset.
+			log.Printf("Tus upload %s interrupted at offset %d: %v", u.ID, u.Offset, err)
+			http.Error(w, "Unable to store chunk", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if u.Offset == u.Length && !u.Completed {
+		if err := h.complete(r.Context(), u); err != nil {
+			h.completeFailed(w, u, err)
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	if u.Completed {
+		if u.Result != "" {
+			w.Header().Set("Upload-Result", u.Result)
+		}
+	} else {
+		w.Header().Set("Upload-Expires", u.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) terminate(w http.ResponseWriter, r *http.Request, id string) {
+	unlock, ok := h.lock(id)
+	if !ok {
+		http.Error(w, "Upload is being written by another request", http.StatusConflict)
+		return
+	}
+	defer unlock()
+
+	if _, ok := h.lookup(w, r, id); !ok {
+		return
+	}
+	h.remove(id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// appendChunk writes the request body to the data file without exceeding the
+// declared upload length.
+func (h *Handler) appendChunk(u *Upload, body io.Reader) (int64, error) {
+	f, err := os.OpenFile(h.dataPath(u.ID), os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(u.Offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(f, io.LimitReader(body, u.Length-u.Offset))
+	if syncErr := f.Sync(); err == nil {
+		err = syncErr
+	}
+	return written, err
+}
+
+func (h *Handler) complete(ctx context.Context, u *Upload) error {
+	f, err := os.Open(h.dataPath(u.ID))
+	if err != nil {
+		return err
+	}
+	result, err := h.OnComplete(ctx, u, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	u.Completed = true
+	u.Result = result
+	if err := h.save(u); err != nil {
+		return err
+	}
+	if err := os.Remove(h.dataPath(u.ID)); err != nil {
+		log.Printf("Failed to remove data of tus upload %s: %v", u.ID, err)
+	}
+	return nil
+}
+
+// completeFailed answers a request whose upload could not be completed. The
+// upload is kept for the client to retry unless it was rejected, see
+// BadRequest.
+func (h *Handler) completeFailed(w http.ResponseWriter, u *Upload, err error) {
+	var bad badRequestError
+	if errors.As(err, &bad) {
+		h.remove(u.ID)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Failed to complete tus upload %s: %v", u.ID, err)
+	http.Error(w, "Unable to process upload", http.StatusInternalServerError)
+}
+
+// lookup loads an upload of the caller, answering 404 for unknown, foreign or
+// expired uploads.
+func (h *Handler) lookup(w http.ResponseWriter, r *http.Request, id string) (*Upload, bool) {
+	u, err := h.load(id)
+	if errors.Is(err, errNotFound) || (err == nil && u.Owner != h.Owner(r)) {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to load tus upload %s: %v", id, err)
+		http.Error(w, "Unable to load upload", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !u.Completed && time.Now().After(u.ExpiresAt) {
+		http.Error(w, "Upload expired", http.StatusGone)
+		return nil, false
+	}
+	return u, true
+}
+
+// RunCleanup removes expired uploads every interval until ctx is done.
+func (h *Handler) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.cleanup()
+		}
+	}
+}
+
+func (h *Handler) cleanup() {
+	infos, err := filepath.Glob(filepath.Join(h.Dir, "*.info"))
+	if err != nil {
+		log.Printf("Failed to list tus uploads: %v", err)
+		return
+	}
+	for _, info := range infos {
+		id := strings.TrimSuffix(filepath.Base(info), ".info")
+		u, err := h.load(id)
+		if err != nil || time.Now().Before(u.ExpiresAt) {
+			continue
+		}
+		unlock, ok := h.lock(id)
+		if !ok {
+			continue
+		}
+		h.remove(id)
+		unlock()
+		log.Printf("Removed expired tus upload %s", id)
+	}
+}
+
+func (h *Handler) lock(id string) (func(), bool) {
+	value, _ := h.locks.LoadOrStore(id, &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	if !mu.TryLock() {
+		return nil, false
+	}
+	return mu.Unlock, true
+}
+
+func (h *Handler) load(id string) (*Upload, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errNotFound
+	}
+	data, err := os.ReadFile(h.infoPath(id))
+	if os.IsNotExist(err) {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var u Upload
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (h *Handler) save(u *Upload) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	tmp := h.infoPath(u.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.infoPath(u.ID))
+}
+
+func (h *Handler) remove(id string) {
+	os.Remove(h.dataPath(id))
+	os.Remove(h.infoPath(id))
+	h.locks.Delete(id)
+}
+
+func (h *Handler) dataPath(id string) string {
+	return filepath.Join(h.Dir, id+".bin")
+}
+
+func (h *Handler) infoPath(id string) string {
+	return filepath.Join(h.Dir, id+".info")
+}
+
+// parseMetadata decodes an Upload-Metadata header: comma separated pairs of
+// a key and an optional base64 encoded value.
+func parseMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, fmt.Errorf("empty metadata key")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("metadata %s: %w", key, err)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
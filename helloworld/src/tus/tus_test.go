@@ -0,0 +1,179 @@
+package tus
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestHandler(t *testing.T, onComplete func(context.Context, *Upload, *os.File) (string, error)) *Handler {
+	t.Helper()
+	return &Handler{
+		BasePath:   "/uploads",
+		Dir:        t.TempDir(),
+		MaxSize:    1 << 20,
+		Expiration: time.Hour,
+		Owner:      func(r *http.Request) string { return r.Header.Get("X-User") },
+		OnComplete: onComplete,
+	}
+}
+
+func do(h *Handler, method, path, user string, headers map[string]string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Tus-Resumable", Version)
+	r.Header.Set("X-User", user)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func create(t *testing.T, h *Handler, user string, length int) string {
+	t.Helper()
+	w := do(h, http.MethodPost, "/uploads", user, map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("cat.jpg")) + ",empty",
+	}, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	return w.Header().Get("Location")
+}
+
+func patch(h *Handler, location, user string, offset int, chunk string) *httptest.ResponseRecorder {
+	return do(h, http.MethodPatch, location, user, map[string]string{
+		"Content-Type":  offsetContentType,
+		"Upload-Offset": strconv.Itoa(offset),
+	}, chunk)
+}
+
+func TestUpload(t *testing.T) {
+	var got string
+	var metadata map[string]string
+	h := newTestHandler(t, func(ctx context.Context, u *Upload, data *os.File) (string, error) {
+		b, err := io.ReadAll(data)
+		got, metadata = string(b), u.Metadata
+		return "job-1", err
+	})
+	location := create(t, h, "alice", 11)
+
+	if w := patch(h, location, "alice", 0, "hello "); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "6" {
+		t.Fatalf("first chunk: %d, offset %s", w.Code, w.Header().Get("Upload-Offset"))
+	}
+	if w := patch(h, location, "alice", 0, "hello "); w.Code != http.StatusConflict {
+		t.Errorf("chunk at a stale offset: %d, want 409", w.Code)
+	}
+	w := do(h, http.MethodHead, location, "alice", nil, "")
+	if w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != "6" || w.Header().Get("Upload-Length") != "11" {
+		t.Errorf("HEAD: %d, offset %s, length %s", w.Code, w.Header().Get("Upload-Offset"), w.Header().Get("Upload-Length"))
+	}
+
+	// Data beyond the declared length is not stored.
+	w = patch(h, location, "alice", 6, "world and more")
+	if w.Code != http.StatusNoContent || w.Header().Get("Upload-Result") != "job-1" {
+		t.Fatalf("last chunk: %d, result %q", w.Code, w.Header().Get("Upload-Result"))
+	}
+	if got != "hello world" {
+		t.Errorf("OnComplete read %q", got)
+	}
+	if metadata["filename"] != "cat.jpg" || metadata["empty"] != "" {
+		t.Errorf("metadata = %v", metadata)
+	}
+	id := strings.TrimPrefix(location, "/uploads/")
+	if _, err := os.Stat(h.dataPath(id)); !os.IsNotExist(err) {
+		t.Errorf("data file of a completed upload still exists: %v", err)
+	}
+}
+
+func TestUploadOfOtherUser(t *testing.T) {
+	h := newTestHandler(t, nil)
+	location := create(t, h, "alice", 5)
+	if w := do(h, http.MethodHead, location, "bob", nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("HEAD by another user: %d, want 404", w.Code)
+	}
+	if w := patch(h, location, "bob", 0, "abc"); w.Code != http.StatusNotFound {
+		t.Errorf("PATCH by another user: %d, want 404", w.Code)
+	}
+}
+
+func TestCompleteErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		kept   bool
+	}{
+		{"rejected", BadRequest(errors.New("unsupported file type")), http.StatusBadRequest, false},
+		{"failed", errors.New("database is down"), http.StatusInternalServerError, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, func(context.Context, *Upload, *os.File) (string, error) {
+				return "", tt.err
+			})
+			location := create(t, h, "alice", 3)
+			if w := patch(h, location, "alice", 0, "abc"); w.Code != tt.status {
+				t.Errorf("PATCH: %d, want %d", w.Code, tt.status)
+			}
+			w := do(h, http.MethodHead, location, "alice", nil, "")
+			if kept := w.Code == http.StatusOK; kept != tt.kept {
+				t.Errorf("HEAD after the failure: %d, upload kept %v, want %v", w.Code, kept, tt.kept)
+			}
+		})
+	}
+}
+
+func TestExpiredUpload(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Expiration = -time.Second
+	location := create(t, h, "alice", 5)
+	if w := patch(h, location, "alice", 0, "abc"); w.Code != http.StatusGone {
+		t.Errorf("PATCH: %d, want 410", w.Code)
+	}
+	h.cleanup()
+	if w := do(h, http.MethodHead, location, "alice", nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("HEAD after cleanup: %d, want 404", w.Code)
+	}
+}
+
+func TestTerminate(t *testing.T) {
+	h := newTestHandler(t, nil)
+	location := create(t, h, "alice", 5)
+	if w := do(h, http.MethodDelete, location, "alice", nil, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE: %d", w.Code)
+	}
+	if w := do(h, http.MethodHead, location, "alice", nil, ""); w.Code != http.StatusNotFound {
+		t.Errorf("HEAD after DELETE: %d, want 404", w.Code)
+	}
+}
+
+func TestCreateInvalid(t *testing.T) {
+	h := newTestHandler(t, nil)
+	for name, headers := range map[string]map[string]string{
+		"no length":    {},
+		"too large":    {"Upload-Length": strconv.Itoa(2 << 20)},
+		"bad metadata": {"Upload-Length": "5", "Upload-Metadata": "filename !!!"},
+	} {
+		if w := do(h, http.MethodPost, "/uploads", "alice", headers, ""); w.Code/100 != 4 {
+			t.Errorf("%s: %d, want a client error", name, w.Code)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/uploads", nil)
+	r.Header.Set("Upload-Length", "5")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("request without Tus-Resumable: %d, want 412", w.Code)
+	}
+}
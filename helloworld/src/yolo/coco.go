@@ -0,0 +1,25 @@
+package yolo
+
+import "strconv"
+
+// CocoNames are the class names of the COCO dataset, indexed by the class
+// id emitted by the pretrained YOLOv5 weights.
+var CocoNames = []string{
+	"person", "bicycle", "car", "motorcycle", "airplane", "bus", "train", "truck", "boat", "traffic light",
+	"fire hydrant", "stop sign", "parking meter", "bench", "bird", "cat", "dog", "horse", "sheep", "cow",
+	"elephant", "bear", "zebra", "giraffe", "backpack", "umbrella", "handbag", "tie", "suitcase", "frisbee",
+	"skis", "snowboard", "sports ball", "kite", "baseball bat", "baseball glove", "skateboard", "surfboard", "tennis racket", "bottle",
+	"wine glass", "cup", "fork", "knife", "spoon", "bowl", "banana", "apple", "sandwich", "orange",
+	"broccoli", "carrot", "hot dog", "pizza", "donut", "cake", "chair", "couch", "potted plant", "bed",
+	"dining table", "toilet", "tv", "laptop", "mouse", "remote", "keyboard", "cell phone", "microwave", "oven",
+	"toaster", "sink", "refrigerator", "book", "clock", "vase", "scissors", "teddy bear", "hair drier", "toothbrush",
+}
+
+// ClassName maps a COCO class id to its name, falling back to the numeric id
+// for classes outside the dataset.
+func ClassName(id int) string {
+	if id >= 0 && id < len(CocoNames) {
+		return CocoNames[id]
+	}
+	return strconv.Itoa(id)
+}
@@ -0,0 +1,87 @@
+package yolo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Detection is a single bounding box written by detect.py with --save-txt.
+// Coordinates are normalized to the image size.
+type Detection struct {
+	ClassID    int
+	ClassName  string
+	XCenter    float64
+	YCenter    float64
+	Width      float64
+	Height     float64
+	Confidence float64
+}
+
+// ParseLabels reads one label file. Lines have the form
+// "class x_center y_center width height [confidence]"; the confidence column
+// is only present when detect.py runs with --save-conf.
+func ParseLabels(r io.Reader) ([]Detection, error) {
+	var detections []Detection
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 5 && len(fields) != 6 {
+			return nil, fmt.Errorf("line %d: expected 5 or 6 fields, got %d", line, len(fields))
+		}
+
+		classID, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid class id %q", line, fields[0])
+		}
+		values := make([]float64, len(fields)-1)
+		for i, field := range fields[1:] {
+			values[i], err = strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid number %q", line, field)
+			}
+		}
+		confidence := 1.0
+		if len(values) == 5 {
+			confidence = values[4]
+		}
+
+		detections = append(detections, Detection{
+			ClassID:    classID,
+			ClassName:  ClassName(classID),
+			XCenter:    values[0],
+			YCenter:    values[1],
+			Width:      values[2],
+			Height:     values[3],
+			Confidence: confidence,
+		})
+	}
+	return detections, scanner.Err()
+}
+
+// LabelFrame reports whether the label file name (without extension) was
+// written for source, and for which frame. Images produce "<stem>.txt";
+// videos produce one "<stem>_<frame>.txt" per frame.
+func LabelFrame(name, source string) (int, bool) {
+	stem := strings.TrimSuffix(path.Base(source), path.Ext(source))
+	if name == stem {
+		return 0, true
+	}
+	suffix, ok := strings.CutPrefix(name, stem+"_")
+	if !ok {
+		return 0, false
+	}
+	frame, err := strconv.Atoi(suffix)
+	if err != nil {
+		return 0, false
+	}
+	return frame, true
+}
@@ -0,0 +1,59 @@
+package yolo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseLabels(t *testing.T) {
+	detections, err := ParseLabels(strings.NewReader("0 0.5 0.5 0.2 0.4 0.87\n\n2 0.1 0.2 0.3 0.4\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Detection{
+		{ClassID: 0, ClassName: "person", XCenter: 0.5, YCenter: 0.5, Width: 0.2, Height: 0.4, Confidence: 0.87},
+		{ClassID: 2, ClassName: "car", XCenter: 0.1, YCenter: 0.2, Width: 0.3, Height: 0.4, Confidence: 1},
+	}
+	if len(detections) != len(want) {
+		t.Fatalf("ParseLabels returned %d detections, want %d", len(detections), len(want))
+	}
+	for i := range want {
+		if detections[i] != want[i] {
+			t.Errorf("detection %d = %+v, want %+v", i, detections[i], want[i])
+		}
+	}
+}
+
+func TestParseLabelsInvalid(t *testing.T) {
+	for _, input := range []string{
+		"0 0.5 0.5 0.2",
+		"0 0.5 0.5 0.2 0.4 0.9 1",
+		"person 0.5 0.5 0.2 0.4",
+		"0 0.5 x 0.2 0.4",
+	} {
+		if _, err := ParseLabels(strings.NewReader(input)); err == nil {
+			t.Errorf("ParseLabels(%q) succeeded", input)
+		}
+	}
+}
+
+func TestLabelFrame(t *testing.T) {
+	tests := []struct {
+		name, source string
+		frame        int
+		ok           bool
+	}{
+		{"photo", "uploads/photo.jpg", 0, true},
+		{"clip_42", "clip.mp4", 42, true},
+		{"clip_x", "clip.mp4", 0, false},
+		{"clip", "other.mp4", 0, false},
+		{"my_clip_7", "my_clip.mp4", 7, true},
+		{"my_clip", "my.mp4", 0, false},
+	}
+	for _, tt := range tests {
+		frame, ok := LabelFrame(tt.name, tt.source)
+		if frame != tt.frame || ok != tt.ok {
+			t.Errorf("LabelFrame(%q, %q) = %d, %v, want %d, %v", tt.name, tt.source, frame, ok, tt.frame, tt.ok)
+		}
+	}
+}